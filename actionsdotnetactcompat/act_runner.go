@@ -1,6 +1,9 @@
 package actionsdotnetactcompat
 
 import (
+	"os"
+	"sort"
+
 	"github.com/ChristopherHX/github-act-runner/actionsrunner"
 	"github.com/ChristopherHX/github-act-runner/protocol"
 )
@@ -10,9 +13,49 @@ type ActRunner struct {
 }
 
 func (arunner *ActRunner) ExecWorker(run *actionsrunner.RunRunner, wc actionsrunner.WorkerContext, jobreq *protocol.AgentJobRequestMessage, src []byte) error {
-	if len(arunner.WorkerArgs) <= 0 {
+	profile := wc.Profile()
+	args, err := profile.WorkerCommand(arunner.WorkerArgs)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return arunner.WorkerRunnerEnvironment.ExecWorker(run, wc, jobreq, src)
+	}
+	if workspace := wc.Workspace(); workspace != nil {
+		// External workers like Runner.Worker of actions/runner apply the workspace options themselves
+		if err := workspace.Clean(jobreq.Workspace); err != nil {
+			wc.FailInitJob("Set up Job", "Failed to clean the workspace: "+err.Error())
+			return nil
+		}
+	}
+	if profile == nil || profile.ContainerRuntime == "" {
 		ExecWorker(jobreq, wc)
 		return nil
 	}
-	return arunner.WorkerRunnerEnvironment.ExecWorker(run, wc, jobreq, src)
+	// act reads DOCKER_HOST from the process environment, run it in the worker command of this executable instead
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	return actionsrunner.ExecExternalWorker(workerArgs(self, wc), wc.WorkDir(), wc, src)
+}
+
+// workerArgs returns the worker command of self with the workspace and the env names of the profile,
+// the values of the env are only passed through the environment of the worker
+func workerArgs(self string, wc actionsrunner.WorkerContext) []string {
+	args := []string{self, "worker"}
+	if workspace := wc.Workspace(); workspace != nil {
+		args = append(args, "--work-folder", workspace.WorkFolder, "--runner-workspace", workspace.RunnerWorkspace, "--workspace", workspace.Workspace)
+	}
+	if profile := wc.Profile(); profile != nil && len(profile.Env) > 0 {
+		names := make([]string, 0, len(profile.Env))
+		for k := range profile.Env {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		for _, k := range names {
+			args = append(args, "--profile-env", k)
+		}
+	}
+	return args
 }
@@ -0,0 +1,26 @@
+package actionsdotnetactcompat
+
+import (
+	"testing"
+
+	"github.com/ChristopherHX/github-act-runner/actionsrunner"
+	"github.com/ChristopherHX/github-act-runner/runnerconfiguration"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkerArgs(t *testing.T) {
+	assert.Equal(t, []string{"runner", "worker"}, workerArgs("runner", &actionsrunner.DefaultWorkerContext{}))
+
+	wc := &actionsrunner.DefaultWorkerContext{
+		ExecutionProfile: &runnerconfiguration.ExecutionProfile{
+			Env:              map[string]string{"B": "secret", "A": "a"},
+			ContainerRuntime: "unix:///run/podman.sock",
+		},
+		JobWorkspace: &actionsrunner.Workspace{WorkFolder: "/work", RunnerWorkspace: "/work/repo", Workspace: "/work/repo/repo"},
+	}
+	assert.Equal(t, []string{
+		"runner", "worker",
+		"--work-folder", "/work", "--runner-workspace", "/work/repo", "--workspace", "/work/repo/repo",
+		"--profile-env", "A", "--profile-env", "B",
+	}, workerArgs("runner", wc), "the values of the env must not be visible in the command line")
+}
@@ -26,7 +26,6 @@ import (
 	"github.com/nektos/act/pkg/container"
 	"github.com/nektos/act/pkg/model"
 	"github.com/nektos/act/pkg/runner"
-	"github.com/rhysd/actionlint"
 	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
 )
@@ -39,6 +38,9 @@ type ghaFormatter struct {
 	main          bool
 	result        *model.StepResult
 	ctx           context.Context
+	matchers      []*problemMatcher
+	summaries     *stepSummaries
+	commands      []*annotationCommand
 }
 
 func flushInternal(rec *protocol.TimelineRecord, res *model.StepResult) {
@@ -51,13 +53,24 @@ func flushInternal(rec *protocol.TimelineRecord, res *model.StepResult) {
 	}
 }
 
+// flushStep completes the record of the current step, act fails steps without logging a result if it cannot evaluate their condition
+func (f *ghaFormatter) flushStep(rec *protocol.TimelineRecord) {
+	if f.rc != nil {
+		if res, ok := f.rc.StepResults[rec.RefName]; ok && res.Conclusion == model.StepStatusFailure {
+			flushInternal(rec, res)
+			return
+		}
+	}
+	flushInternal(rec, f.result)
+}
+
 func (f *ghaFormatter) Flush() {
 	cur := f.logger.Current()
 	if cur == nil {
 		return
 	}
 	if f.result != nil {
-		flushInternal(cur, f.result)
+		f.flushStep(cur)
 	} else if cur.Result == nil {
 		// If act fails during init e.g to docker is not running
 		cur.Complete("Failed")
@@ -84,6 +97,10 @@ func (f *ghaFormatter) Format(entry *logrus.Entry) ([]byte, error) {
 	var stepID string
 	stage, hasStage := entry.Data["stage"]
 	rawStepID, hasStepID := entry.Data["stepID"]
+	if hasStepID && strings.HasPrefix(entry.Message, "\u2B50 Run ") {
+		// act has replaced the log writer of the job container before logging the start of a step
+		f.recordCommands()
+	}
 
 	if stepResult, hasStepResult := entry.Data["stepResult"]; hasStepResult {
 		res := stepResult
@@ -114,7 +131,7 @@ func (f *ghaFormatter) Format(entry *logrus.Entry) ([]byte, error) {
 		if stage == "Post" {
 			f.Flush()
 		} else if f.result != nil {
-			flushInternal(cur, f.result)
+			f.flushStep(cur)
 		}
 		f.result = &model.StepResult{Conclusion: model.StepStatusSuccess}
 		if stage != "Main" {
@@ -137,32 +154,32 @@ func (f *ghaFormatter) Format(entry *logrus.Entry) ([]byte, error) {
 				next.Complete("Skipped")
 			}
 			if cur := f.logger.Current(); cur != nil {
+				// act evaluates the name of the step when it starts
+				if name, ok := stepName.(string); ok && name != "" {
+					cur.Name = name
+				}
 				cur.Start()
 			}
 			f.logger.Update()
 		}
 	}
-	if f.rqt.MaskHints != nil {
-		for _, v := range f.rqt.MaskHints {
-			if strings.ToLower(v.Type) == "regex" {
-				r, _ := regexp.Compile(v.Value)
-				entry.Message = r.ReplaceAllString(entry.Message, "***")
-			}
-		}
-	}
-	if f.rqt.Variables != nil {
-		for _, v := range f.rqt.Variables {
-			if v.IsSecret && len(v.Value) > 0 && !strings.EqualFold(v.Value, "true") && !strings.EqualFold(v.Value, "false") && !strings.EqualFold(v.Value, "0") && !strings.EqualFold(v.Value, "1") {
-				entry.Message = strings.ReplaceAll(entry.Message, v.Value, "***")
-			}
-		}
-	}
+	entry.Message = f.mask(entry.Message)
 
 	if f.linefeedregex == nil {
 		f.linefeedregex = regexp.MustCompile(`(\r\n|\r|\n)`)
 	}
 
-	prefix := entry.Time.UTC().Format(protocol.TimestampOutputFormat) + " "
+	timestamp := entry.Time.UTC().Format(protocol.TimestampOutputFormat) + " "
+	warnings := f.matchProblems(entry)
+	if stepResult, hasStepResult := entry.Data["stepResult"]; hasStepResult && hasStepID {
+		stepIDArray, _ := rawStepID.([]string)
+		warnings = append(warnings, f.collectStepSummary(stepResult, stepIDArray)...)
+	}
+	for _, warning := range warnings {
+		b.WriteString(timestamp + "##[warning]" + warning + "\n")
+	}
+	f.annotate(entry)
+	prefix := timestamp
 	if entry.Level == logrus.DebugLevel {
 		prefix += "##[debug]"
 	} else if entry.Level == logrus.WarnLevel {
@@ -177,6 +194,34 @@ func (f *ghaFormatter) Format(entry *logrus.Entry) ([]byte, error) {
 	return b.Bytes(), nil
 }
 
+// mask replaces the secrets of the job in s
+func (f *ghaFormatter) mask(s string) string {
+	if f.rqt.MaskHints != nil {
+		for _, v := range f.rqt.MaskHints {
+			if strings.ToLower(v.Type) == "regex" {
+				r, _ := regexp.Compile(v.Value)
+				s = r.ReplaceAllString(s, "***")
+			}
+		}
+	}
+	if f.rqt.Variables != nil {
+		for _, v := range f.rqt.Variables {
+			if v.IsSecret && len(v.Value) > 0 && !strings.EqualFold(v.Value, "true") && !strings.EqualFold(v.Value, "false") && !strings.EqualFold(v.Value, "0") && !strings.EqualFold(v.Value, "1") {
+				s = strings.ReplaceAll(s, v.Value, "***")
+			}
+		}
+	}
+	if f.rc != nil {
+		// Values of ::add-mask::, act already masks them in the log
+		for _, v := range f.rc.Masks {
+			if v != "" {
+				s = strings.ReplaceAll(s, v, "***")
+			}
+		}
+	}
+	return s
+}
+
 type JobLoggerFactory struct {
 	Logger *logrus.Logger
 }
@@ -242,6 +287,17 @@ func ExecWorker(rqt *protocol.AgentJobRequestMessage, wc actionsrunner.WorkerCon
 		failInitJob(err.Error())
 		return
 	}
+	if profile := wc.Profile(); profile != nil {
+		for k, v := range profile.Env {
+			if _, ok := env[k]; !ok {
+				env[k] = v
+			}
+		}
+	}
+	workspace := wc.Workspace()
+	if workspace != nil {
+		env["RUNNER_WORKSPACE"] = workspace.RunnerWorkspace
+	}
 	env["ACTIONS_RUNTIME_URL"] = vssConnection.TenantURL
 	env["ACTIONS_RUNTIME_TOKEN"] = vssConnection.Token
 
@@ -261,11 +317,6 @@ func ExecWorker(rqt *protocol.AgentJobRequestMessage, wc actionsrunner.WorkerCon
 		failInitJob(err.Error())
 		return
 	}
-	steps, err := ConvertSteps(rqt.Steps)
-	if err != nil {
-		failInitJob(err.Error())
-		return
-	}
 	actions_step_debug := false
 	if sd, ok := rqt.Variables["ACTIONS_STEP_DEBUG"]; ok && (sd.Value == "true" || sd.Value == "1") {
 		actions_step_debug = true
@@ -274,7 +325,6 @@ func ExecWorker(rqt *protocol.AgentJobRequestMessage, wc actionsrunner.WorkerCon
 	if rqt.JobContainer != nil {
 		rawContainer = *rqt.JobContainer.ToYamlNode()
 	}
-	services, err := ConvertServiceContainer(rqt.JobServiceContainers)
 	githubCtxMap, ok := githubCtx.(map[string]interface{})
 	if !ok {
 		failInitJob("Github ctx is not a map")
@@ -290,6 +340,16 @@ func ExecWorker(rqt *protocol.AgentJobRequestMessage, wc actionsrunner.WorkerCon
 	if runtime.GOOS == "windows" {
 		runnerConfig.Workdir = ".\\"
 	}
+	if wd := wc.WorkDir(); wd != "" {
+		if err := os.MkdirAll(wd, 0777); err != nil {
+			failInitJob("Failed to create the work directory: " + err.Error())
+			return
+		}
+		if abs, err := filepath.Abs(wd); err == nil {
+			wd = abs
+		}
+		runnerConfig.Workdir = wd
+	}
 	runnerConfig.Platforms = map[string]string{
 		"dummy": "-self-hosted",
 	}
@@ -401,9 +461,7 @@ func ExecWorker(rqt *protocol.AgentJobRequestMessage, wc actionsrunner.WorkerCon
 						If:           yaml.Node{Value: "always()"},
 						Name:         rqt.JobDisplayName,
 						RawRunsOn:    yaml.Node{Kind: yaml.ScalarNode, Value: "dummy"},
-						Steps:        steps,
 						RawContainer: rawContainer,
-						Services:     services,
 						Outputs:      make(map[string]string),
 					},
 				},
@@ -477,6 +535,9 @@ func ExecWorker(rqt *protocol.AgentJobRequestMessage, wc actionsrunner.WorkerCon
 		}
 	}
 	rc.ContextData["github"] = githubCtxMap
+	if workspace != nil {
+		rc.ContextData["runner"] = newRunnerContext(rc, workspace.RunnerWorkspace)
+	}
 	val, _ := json.Marshal(githubCtx)
 	sv := string(val)
 	rc.GHContextData = &sv
@@ -484,7 +545,17 @@ func ExecWorker(rqt *protocol.AgentJobRequestMessage, wc actionsrunner.WorkerCon
 	ee := rc.NewExpressionEvaluator(jobExecCtx)
 	rc.ExprEval = ee
 
+	steps, stepHooks, err := ConvertSteps(rqt.Steps)
+	if err != nil {
+		failInitJob(err.Error())
+		return
+	}
+	rc.Run.Workflow.Jobs[rqt.JobID].Steps = steps
+
 	formatter.rc = rc
+	if jlogger.CanUploadStepSummary() {
+		formatter.summaries = newStepSummaries(jlogger)
+	}
 	if actions_step_debug {
 		logger.SetLevel(logrus.DebugLevel)
 	} else {
@@ -495,20 +566,9 @@ func ExecWorker(rqt *protocol.AgentJobRequestMessage, wc actionsrunner.WorkerCon
 
 	eval := rc.NewExpressionEvaluator(jobExecCtx)
 	for i := 0; i < len(steps); i++ {
-		rec := protocol.CreateTimelineEntry(rqt.JobID, steps[i].ID, steps[i].String())
+		rec := protocol.CreateTimelineEntry(rqt.JobID, steps[i].ID, stepHooks.DisplayName(steps[i]))
 		rec.ID = rqt.Steps[i].ID // This allows the actions_runner adapter to work in gitea
-		parser := actionlint.NewExprParser()
-		exprNode, err := parser.Parse(actionlint.NewExprLexer(strings.TrimPrefix(rec.Name, "${{")))
-		canEvaluateNow := err == nil
-		actionlint.VisitExprNode(exprNode, func(node, _ actionlint.ExprNode, entering bool) {
-			if variableNode, ok := node.(*actionlint.VariableNode); entering && ok {
-				switch strings.ToLower(variableNode.Name) {
-				case "env", "steps", "job":
-					canEvaluateNow = false
-				}
-			}
-		})
-		if canEvaluateNow {
+		if canEvaluateNow(rec.Name) {
 			rec.Name = eval.Interpolate(jobExecCtx, rec.Name)
 		}
 		jlogger.Append(rec).Order = int32(i + len(steps) + 1)
@@ -522,6 +582,59 @@ func ExecWorker(rqt *protocol.AgentJobRequestMessage, wc actionsrunner.WorkerCon
 	if err := os.MkdirAll(cacheDir, 0777); err != nil {
 		logger.Warn("github-act-runner is be unable to access \"" + cacheDir + "\". You might want set one of the following environment variables XDG_CACHE_HOME, HOME to a user read and writeable location. Details: " + err.Error())
 	}
+	services, err := ConvertServiceContainer(jobExecCtx, ee, rqt.JobServiceContainers)
+	if err != nil {
+		failInitJob(err.Error())
+		return
+	}
+	// Like actions/runner the job started hook runs before the service containers are started
+	hookEnviron := jobHookEnviron(env, githubCtxMap)
+	if script := lookupJobHook(wc, JobStartedHookEnv); script != "" {
+		rec := startJobRecord(jlogger, "__job_started_hook", "Set up runner")
+		if err := runJobHook(jobExecCtx, logger, script, runnerConfig.Workdir, hookEnviron); err != nil {
+			wc.FailInitJob("Failed to run the job started hook", err.Error())
+			return
+		}
+		rec.Complete("Succeeded")
+		// The rest of the setup must not be logged to the completed record of the hook
+		if len(services) > 0 || rqt.JobContainer != nil {
+			startJobRecord(jlogger, "__initialize_containers", "Initialize containers")
+		} else {
+			startJobRecord(jlogger, "__setup_act", "Set up nektos/act")
+		}
+	}
+	var jobServices *serviceContainers
+	removeServices := func() {
+		if jobServices == nil {
+			return
+		}
+		if err := jobServices.Remove(common.WithLogger(context.Background(), logger)); err != nil {
+			logger.Warnf("Failed to remove the service containers: %v", err)
+		}
+		jobServices = nil
+	}
+	if len(services) > 0 {
+		job := rc.Run.Workflow.Jobs[rqt.JobID]
+		job.Services = services
+		jobServices, err = startServiceContainers(common.WithLogger(jobExecCtx, logger), services, runnerConfig.ContainerArchitecture)
+		if err != nil {
+			removeServices()
+			failInitJob("Failed to start the service containers: " + err.Error())
+			return
+		}
+		// Removes the service containers if the job ends early, e.g. due to a panic of act
+		defer removeServices()
+		jobCtx := &jobContext{
+			Status:    jobStatus{rc: rc},
+			Container: jobContainerContext{Network: jobServices.Network},
+			Services:  jobServices.Context,
+		}
+		if rqt.JobContainer != nil {
+			jobCtx.Container.Network = addContainerNetwork(&job.RawContainer, jobServices.Network)
+			jobCtx.Container.ID = jobContainerID{services: jobServices}
+		}
+		rc.ContextData["job"] = jobCtx
+	}
 	logger.Println("Starting nektos/act")
 	select {
 	case <-jobExecCtx.Done():
@@ -539,6 +652,7 @@ func ExecWorker(rqt *protocol.AgentJobRequestMessage, wc actionsrunner.WorkerCon
 		}()
 		ctxError = context.WithValue(ctxError, common.JobCancelCtxVal, jobExecCtx)
 		formatter.ctx = ctxError
+		stepHooks.Attach(ctxError, rc)
 		err = rc.Executor()(ctxError)
 		if err == nil {
 			err = common.JobError(ctxError)
@@ -553,6 +667,13 @@ func ExecWorker(rqt *protocol.AgentJobRequestMessage, wc actionsrunner.WorkerCon
 		jobStatus = "Failed"
 	}
 	formatter.Flush()
+	// Remove them while the log of the job is still open
+	removeServices()
+	if formatter.summaries != nil {
+		for _, err := range formatter.summaries.Wait() {
+			logger.Warn(err.Error())
+		}
+	}
 
 	// Prepare results for github server
 	if rqt.JobOutputs != nil {
@@ -563,6 +684,19 @@ func ExecWorker(rqt *protocol.AgentJobRequestMessage, wc actionsrunner.WorkerCon
 		}
 	}
 
+	if script := lookupJobHook(wc, JobCompletedHookEnv); script != "" {
+		// Runs even if the job has been cancelled, e.g. to clean up the machine
+		rec := startJobRecord(jlogger, "__job_completed_hook", "Complete runner")
+		if err := runJobHook(context.Background(), logger, script, runnerConfig.Workdir, hookEnviron); err != nil {
+			logger.Logf(logrus.ErrorLevel, "%v", err.Error())
+			rec.Complete("Failed")
+			jobStatus = "Failed"
+		} else {
+			rec.Complete("Succeeded")
+		}
+		jlogger.MoveNext()
+	}
+
 	select {
 	case <-jobExecCtx.Done():
 		jobStatus = "Canceled"
@@ -642,3 +776,50 @@ func extractTarGz(reader io.Reader, dir string) error {
 	defer gzr.Close()
 	return container.ExtractTar(gzr, dir)
 }
+
+// runnerContext adds runner.workspace to the runner context of act, the other values are read from the job container of act
+// to match the RUNNER_* environment variables of the steps
+type runnerContext struct {
+	OS        runnerContextValue `json:"os"`
+	Arch      runnerContextValue `json:"arch"`
+	Temp      runnerContextValue `json:"temp"`
+	ToolCache runnerContextValue `json:"tool_cache"`
+	Workspace runnerWorkspace    `json:"workspace"`
+}
+
+func newRunnerContext(rc *runner.RunContext, workspace string) *runnerContext {
+	return &runnerContext{
+		OS:        runnerContextValue{rc: rc, name: "os"},
+		Arch:      runnerContextValue{rc: rc, name: "arch"},
+		Temp:      runnerContextValue{rc: rc, name: "temp"},
+		ToolCache: runnerContextValue{rc: rc, name: "tool_cache"},
+		Workspace: runnerWorkspace{rc: rc, path: workspace},
+	}
+}
+
+// runnerWorkspace is the path of the runner workspace inside of the job container
+type runnerWorkspace struct {
+	rc   *runner.RunContext
+	path string
+}
+
+func (w runnerWorkspace) MarshalText() ([]byte, error) {
+	if w.rc.JobContainer == nil {
+		return []byte(w.path), nil
+	}
+	return []byte(w.rc.JobContainer.ToContainerPath(w.path)), nil
+}
+
+// runnerContextValue is read whenever it is used, act creates the job container after the context data has been set
+type runnerContextValue struct {
+	rc   *runner.RunContext
+	name string
+}
+
+func (v runnerContextValue) MarshalText() ([]byte, error) {
+	if v.rc.JobContainer == nil {
+		return []byte{}, nil
+	}
+	value, _ := v.rc.JobContainer.GetRunnerContext(context.Background())[v.name].(string)
+	return []byte(value), nil
+}
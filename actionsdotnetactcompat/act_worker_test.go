@@ -0,0 +1,212 @@
+package actionsdotnetactcompat
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/ChristopherHX/github-act-runner/actionsrunner"
+	"github.com/ChristopherHX/github-act-runner/protocol"
+	"github.com/ChristopherHX/github-act-runner/protocol/fakeservice"
+	"github.com/ChristopherHX/github-act-runner/protocol/logger"
+	"github.com/google/uuid"
+	"github.com/nektos/act/pkg/container"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatUpdatesStepName(t *testing.T) {
+	server := fakeservice.NewServer()
+	defer server.Close()
+	jobreq := server.NewJob("build")
+	con, _, err := jobreq.GetConnection("SystemVssConnection")
+	if err != nil {
+		t.Fatal(err)
+	}
+	jlogger := &logger.JobLogger{
+		JobRequest:      jobreq,
+		Connection:      con,
+		TimelineRecords: &protocol.TimelineRecordWrapper{},
+	}
+	job := jlogger.Append(protocol.CreateTimelineEntry("", jobreq.JobName, jobreq.JobDisplayName))
+	job.ID = jobreq.JobID
+	skipped := jlogger.Append(protocol.CreateTimelineEntry(jobreq.JobID, "skipped", "Skipped step"))
+	rec := jlogger.Append(protocol.CreateTimelineEntry(jobreq.JobID, "step", "step"))
+	f := &ghaFormatter{rqt: jobreq, logger: jlogger}
+	_, err = f.Format(&logrus.Entry{
+		Level:   logrus.InfoLevel,
+		Message: "⭐ Run Main Build linux",
+		Data:    logrus.Fields{"stepID": []string{"step"}, "stage": "Main", "step": "Build linux"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, rec, jlogger.Current())
+	assert.Equal(t, "Skipped step", skipped.Name)
+
+	timeline := server.Timeline(jobreq.Timeline.ID)
+	if assert.Len(t, timeline, 3) {
+		assert.Equal(t, "Build linux", timeline[2].Name)
+	}
+}
+
+func TestRunnerContext(t *testing.T) {
+	ctx := context.Background()
+	rc := newStepTestRunContext()
+	rc.ContextData["runner"] = newRunnerContext(rc, "/work/repo")
+	ee := rc.NewExpressionEvaluator(ctx)
+	assert.Equal(t, "/work/repo", ee.Interpolate(ctx, "${{ runner.temp }}${{ runner.workspace }}"))
+
+	rc.JobContainer = &container.HostEnvironment{TmpDir: "/cache/tmp", ToolCache: "/cache/tool_cache", Workdir: "/work/repo/repo", Path: "/cache/hostexecutor"}
+	expected := ""
+	for _, name := range []string{"os", "arch", "temp", "tool_cache"} {
+		expected += rc.JobContainer.GetRunnerContext(ctx)[name].(string) + " "
+	}
+	assert.Equal(t, expected+"/work/repo", ee.Interpolate(ctx, "${{ runner.os }} ${{ runner.arch }} ${{ runner.temp }} ${{ runner.tool_cache }} ${{ runner.workspace }}"))
+	assert.Contains(t, expected, "/cache/tmp /cache/tool_cache")
+}
+
+// pathMappingContainer maps the paths of the host like a job container
+type pathMappingContainer struct {
+	*container.HostEnvironment
+}
+
+func (pathMappingContainer) ToContainerPath(path string) string {
+	return "/mnt" + path
+}
+
+func TestRunnerContextWorkspaceOfJobContainer(t *testing.T) {
+	ctx := context.Background()
+	rc := newStepTestRunContext()
+	rc.ContextData["runner"] = newRunnerContext(rc, "/work/repo")
+	rc.JobContainer = pathMappingContainer{&container.HostEnvironment{}}
+	assert.Equal(t, "/mnt/work/repo", rc.NewExpressionEvaluator(ctx).Interpolate(ctx, "${{ runner.workspace }}"))
+}
+
+// runActJob runs the steps with act on the host and returns the timeline records of the steps and their logs
+func runActJob(t *testing.T, steps []protocol.ActionStep) (string, map[string]protocol.TimelineRecord, map[string]string) {
+	server := fakeservice.NewServer()
+	defer server.Close()
+	jobreq := server.NewJob("build")
+	jobreq.ContextData = map[string]protocol.PipelineContextData{
+		"github": protocol.ToPipelineContextData(map[string]interface{}{
+			"workflow":    "test",
+			"event_name":  "push",
+			"event":       map[string]interface{}{},
+			"repository":  "owner/repo",
+			"sha":         "0000000000000000000000000000000000000000",
+			"ref":         "refs/heads/main",
+			"server_url":  "https://github.com",
+			"api_url":     "https://api.github.com",
+			"graphql_url": "https://api.github.com/graphql",
+		}),
+	}
+	for i := range steps {
+		steps[i].ID = uuid.NewString()
+		if steps[i].Reference.Type == "" {
+			steps[i].Reference.Type = "script"
+		}
+		if steps[i].Condition == "" {
+			steps[i].Condition = "success()"
+		}
+	}
+	jobreq.Steps = steps
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	wc := &actionsrunner.DefaultWorkerContext{
+		RunnerMessage:       jobreq,
+		JobExecutionContext: ctx,
+		RunnerLogger:        &actionsrunner.ConsoleLogger{},
+		WorkDirectory:       t.TempDir(),
+	}
+	wc.Init()
+	wc.Logger().Append(protocol.CreateTimelineEntry(jobreq.JobID, "__setup", "Set up Job")).Start()
+	wc.Logger().MoveNext()
+	ExecWorker(jobreq, wc)
+
+	result, err := server.WaitForJob(jobreq.JobID, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := map[string]protocol.TimelineRecord{}
+	logs := map[string]string{}
+	for _, rec := range server.Timeline(jobreq.Timeline.ID) {
+		records[rec.RefName] = rec
+		if rec.Log != nil {
+			logs[rec.RefName] = server.Log(rec.Log.ID)
+		}
+	}
+	return result, records, logs
+}
+
+func TestExecWorkerEvaluatesStepsWhenTheyStart(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("act runs the script steps with bash")
+	}
+	first := protocol.ActionStep{
+		ContextName: "first",
+		Inputs:      toTemplateToken(t, "script: |-\n  echo \"name=world\" >> \"$GITHUB_OUTPUT\"\n  echo \"minutes=1.5\" >> \"$GITHUB_OUTPUT\""),
+	}
+	result, records, logs := runActJob(t, []protocol.ActionStep{
+		first,
+		{
+			ContextName:      "dynamic",
+			DisplayNameToken: toTemplateToken(t, "Hello ${{ steps.first.outputs.name }}"),
+			TimeoutInMinutes: toTemplateToken(t, "${{ steps.first.outputs.minutes }}"),
+			Inputs:           toTemplateToken(t, `${{ fromJSON(format('{{"script":"echo dynamic-{0}"}}', steps.first.outputs.name)) }}`),
+		},
+		{
+			ContextName:     "invalid",
+			ContinueOnError: toTemplateToken(t, "[true]"),
+			Inputs:          toTemplateToken(t, "script: echo invalid-ran"),
+		},
+		{
+			ContextName: "skipped",
+			Inputs:      toTemplateToken(t, "script: echo skipped-ran"),
+		},
+	})
+	assert.Equal(t, "Failed", result)
+
+	dynamic := records["dynamic"]
+	assert.Equal(t, "Hello world", dynamic.Name)
+	if assert.NotNil(t, dynamic.Result) {
+		assert.Equal(t, "Succeeded", *dynamic.Result)
+	}
+	assert.Contains(t, logs["dynamic"], "dynamic-world")
+
+	invalid := records["invalid"]
+	if assert.NotNil(t, invalid.Result) {
+		assert.Equal(t, "Failed", *invalid.Result, "act fails the step without logging its result")
+	}
+	assert.Contains(t, logs["invalid"], "continue-on-error: expected a string")
+	assert.NotContains(t, logs["invalid"], "invalid-ran")
+
+	skipped := records["skipped"]
+	if assert.NotNil(t, skipped.Result) {
+		assert.Equal(t, "Skipped", *skipped.Result)
+	}
+
+	result, records, logs = runActJob(t, []protocol.ActionStep{
+		first,
+		{
+			ContextName:      "timeout",
+			TimeoutInMinutes: toTemplateToken(t, "${{ steps.first.outputs.name }}"),
+			Inputs:           toTemplateToken(t, "script: echo timeout-ran"),
+		},
+		{
+			ContextName: "inputs",
+			Condition:   "always()",
+			Inputs:      toTemplateToken(t, `${{ fromJSON(format('{{"shell":"{0}"}}', steps.first.outputs.name)) }}`),
+		},
+	})
+	assert.Equal(t, "Failed", result)
+	if assert.NotNil(t, records["timeout"].Result) {
+		assert.Equal(t, "Failed", *records["timeout"].Result)
+	}
+	assert.Contains(t, logs["timeout"], "timeout-minutes: world is not a number")
+	assert.NotContains(t, logs["timeout"], "timeout-ran")
+	if assert.NotNil(t, records["inputs"].Result) {
+		assert.Equal(t, "Failed", *records["inputs"].Result)
+	}
+	assert.Contains(t, logs["inputs"], "missing script")
+	assert.NotContains(t, logs["inputs"], "\u2B50 Run", "the placeholder of the script must not run")
+}
@@ -0,0 +1,166 @@
+package actionsdotnetactcompat
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/ChristopherHX/github-act-runner/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+// act logs the whole line of ::notice:: commands
+var noticeCommand = regexp.MustCompile(`^\s*\x{2753}\s+::notice(?: ([^:]*))?::(.*)$`)
+
+// Same patterns as the command handler of act, which only logs the message of ::error:: and ::warning:: commands
+var (
+	commandGA  = regexp.MustCompile("^::([^ ]+)( (.+))?::([^\r\n]*)[\r\n]+$")
+	commandADO = regexp.MustCompile("^##\\[([^ ]+)( (.+))?]([^\r\n]*)[\r\n]+$")
+)
+
+var (
+	commandDataEscapes     = strings.NewReplacer("%25", "%", "%0D", "\r", "%0A", "\n")
+	commandPropertyEscapes = strings.NewReplacer("%25", "%", "%0D", "\r", "%0A", "\n", "%3A", ":", "%2C", ",")
+)
+
+type annotationCommand struct {
+	Type string
+	Data map[string]string
+}
+
+// parseAnnotationProperties returns the properties of an annotation command which are stored in the data of an issue
+func parseAnnotationProperties(properties string, separator string) map[string]string {
+	data := map[string]string{}
+	for _, property := range strings.Split(properties, separator) {
+		kv := strings.SplitN(property, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(kv[0])
+		switch key {
+		case "file", "line", "col", "endLine", "endColumn", "title":
+			if value := commandPropertyEscapes.Replace(kv[1]); value != "" {
+				data[key] = value
+			}
+		case "column":
+			data["col"] = commandPropertyEscapes.Replace(kv[1])
+		}
+	}
+	return data
+}
+
+// parseCommand returns the name, the properties and the argument of a command in a line of the step output
+func parseCommand(line string) (name string, properties string, separator string, arg string, ok bool) {
+	if m := commandGA.FindStringSubmatch(line); m != nil {
+		return m[1], m[3], ",", m[4], true
+	}
+	if m := commandADO.FindStringSubmatch(line); m != nil {
+		return m[1], m[3], ";", m[4], true
+	}
+	return "", "", "", "", false
+}
+
+// commandRecorder records the ::error:: and ::warning:: commands of the step output, before the command handler of act drops their properties
+type commandRecorder struct {
+	out  io.Writer
+	f    *ghaFormatter
+	line bytes.Buffer
+	// resumeCommand is the token of ::stop-commands::, act ignores all other commands until it is used as a command
+	resumeCommand string
+}
+
+// record returns the annotation command of a line, it tracks ::stop-commands:: the same way as the command handler of act
+func (w *commandRecorder) record(line string) *annotationCommand {
+	name, properties, separator, arg, ok := parseCommand(line)
+	if !ok || w.resumeCommand != "" && name != w.resumeCommand {
+		return nil
+	}
+	switch name {
+	case "error", "warning":
+		return &annotationCommand{Type: name, Data: parseAnnotationProperties(properties, separator)}
+	case "stop-commands":
+		w.resumeCommand = commandDataEscapes.Replace(arg)
+	case w.resumeCommand:
+		w.resumeCommand = ""
+	}
+	return nil
+}
+
+func (w *commandRecorder) Write(p []byte) (int, error) {
+	w.line.Write(p)
+	for {
+		i := bytes.IndexByte(w.line.Bytes(), '\n')
+		if i == -1 {
+			break
+		}
+		if cmd := w.record(string(w.line.Next(i + 1))); cmd != nil {
+			w.f.commands = append(w.f.commands, cmd)
+		}
+	}
+	n, err := w.out.Write(p)
+	// act handles complete lines synchronously, commands left over have not been logged
+	w.f.commands = nil
+	return n, err
+}
+
+// recordCommands routes the output of the current step through a commandRecorder
+func (f *ghaFormatter) recordCommands() {
+	if f.rc == nil || f.rc.JobContainer == nil {
+		return
+	}
+	stdout, stderr := f.rc.JobContainer.ReplaceLogWriter(nil, nil)
+	if _, ok := stdout.(*commandRecorder); !ok && stdout != nil {
+		recorder := &commandRecorder{out: stdout, f: f}
+		if stderr == stdout {
+			stderr = recorder
+		}
+		stdout = recorder
+	}
+	if _, ok := stderr.(*commandRecorder); !ok && stderr != nil {
+		stderr = &commandRecorder{out: stderr, f: f}
+	}
+	f.rc.JobContainer.ReplaceLogWriter(stdout, stderr)
+}
+
+// annotate adds the annotation commands of the log to the issues of the current timeline record.
+// ::notice:: commands are replaced by their message
+func (f *ghaFormatter) annotate(entry *logrus.Entry) {
+	cur := f.logger.Current()
+	if cur == nil || entry.Data["raw_output"] == true {
+		return
+	}
+	message := strings.TrimRight(entry.Message, "\r\n")
+	if m := noticeCommand.FindStringSubmatch(message); m != nil {
+		message := commandDataEscapes.Replace(m[2])
+		addIssue(cur, protocol.Issue{
+			Type:     "notice",
+			Category: "General",
+			Message:  message,
+			Data:     parseAnnotationProperties(m[1], ","),
+		})
+		entry.Message = "##[notice]" + message
+		return
+	}
+	var issueType string
+	switch entry.Level {
+	case logrus.ErrorLevel:
+		issueType = "error"
+	case logrus.WarnLevel:
+		issueType = "warning"
+	default:
+		return
+	}
+	// Only errors and warnings of the workflow commands are annotations, not the ones of the runner itself
+	if len(f.commands) == 0 || f.commands[0].Type != issueType {
+		return
+	}
+	cmd := f.commands[0]
+	f.commands = f.commands[1:]
+	addIssue(cur, protocol.Issue{
+		Type:     cmd.Type,
+		Category: "General",
+		Message:  message,
+		Data:     cmd.Data,
+	})
+}
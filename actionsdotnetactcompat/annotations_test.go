@@ -0,0 +1,165 @@
+package actionsdotnetactcompat
+
+import (
+	"io"
+	"testing"
+
+	"github.com/ChristopherHX/github-act-runner/protocol"
+	"github.com/ChristopherHX/github-act-runner/protocol/logger"
+	"github.com/nektos/act/pkg/container"
+	"github.com/nektos/act/pkg/runner"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAnnotationProperties(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		properties string
+		separator  string
+		expected   map[string]string
+	}{
+		{"empty", "", ",", map[string]string{}},
+		{"location", "file=src/main.go,line=3,col=5,endLine=4,endColumn=6", ",", map[string]string{"file": "src/main.go", "line": "3", "col": "5", "endLine": "4", "endColumn": "6"}},
+		{"column alias", "column=7", ",", map[string]string{"col": "7"}},
+		{"title escapes", "title=a%3Ab%2Cc%25", ",", map[string]string{"title": "a:b,c%"}},
+		{"unknown and empty", "name=x,file=,line", ",", map[string]string{}},
+		{"azure pipelines separator", "sourcepath=a;file=b.go;line=1", ";", map[string]string{"file": "b.go", "line": "1"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, parseAnnotationProperties(tc.properties, tc.separator))
+		})
+	}
+}
+
+// actWriter logs the lines of the step output like the command handler of act
+type actWriter struct {
+	f       *ghaFormatter
+	entries []*logrus.Entry
+}
+
+func (w *actWriter) Write(p []byte) (int, error) {
+	for _, entry := range w.entries {
+		w.f.annotate(entry)
+	}
+	return len(p), nil
+}
+
+func TestAnnotate(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		output   string
+		entries  []*logrus.Entry
+		expected []protocol.Issue
+	}{
+		{
+			name:    "error with properties",
+			output:  "::error file=main.go,line=3,col=2,endLine=4,endColumn=9,title=Build::build failed\n",
+			entries: []*logrus.Entry{{Level: logrus.ErrorLevel, Message: "build failed"}},
+			expected: []protocol.Issue{{Type: "error", Category: "General", Message: "build failed", Data: map[string]string{
+				"file": "main.go", "line": "3", "col": "2", "endLine": "4", "endColumn": "9", "title": "Build",
+			}}},
+		},
+		{
+			name:     "warning without properties",
+			output:   "::warning::deprecated\r\n",
+			entries:  []*logrus.Entry{{Level: logrus.WarnLevel, Message: "deprecated"}},
+			expected: []protocol.Issue{{Type: "warning", Category: "General", Message: "deprecated", Data: map[string]string{}}},
+		},
+		{
+			name:   "multiple commands",
+			output: "::warning file=a.go::first\n##[error sourcepath=b.go;line=2]second\n",
+			entries: []*logrus.Entry{
+				{Level: logrus.WarnLevel, Message: "first"},
+				{Level: logrus.ErrorLevel, Message: "second"},
+			},
+			expected: []protocol.Issue{
+				{Type: "warning", Category: "General", Message: "first", Data: map[string]string{"file": "a.go"}},
+				{Type: "error", Category: "General", Message: "second", Data: map[string]string{"line": "2"}},
+			},
+		},
+		{
+			name:     "notice",
+			output:   "::notice file=a.go,line=1::hello%0Aworld\n",
+			entries:  []*logrus.Entry{{Level: logrus.InfoLevel, Message: "  ❓  ::notice file=a.go,line=1::hello%0Aworld\n"}},
+			expected: []protocol.Issue{{Type: "notice", Category: "General", Message: "hello\nworld", Data: map[string]string{"file": "a.go", "line": "1"}}},
+		},
+		{
+			name:    "warnings of the runner",
+			output:  "hello\n",
+			entries: []*logrus.Entry{{Level: logrus.WarnLevel, Message: "Failed to remove the service containers"}},
+		},
+		{
+			name:    "commands are disabled",
+			output:  "::error::not a command\n",
+			entries: []*logrus.Entry{{Level: logrus.InfoLevel, Message: "::error::not a command\n", Data: logrus.Fields{"raw_output": true}}},
+		},
+		{
+			name:   "stop commands",
+			output: "::stop-commands::pause\n::error file=a.go::disabled\n::pause::\n::error file=b.go::enabled\n",
+			entries: []*logrus.Entry{
+				{Level: logrus.InfoLevel, Message: "::error file=a.go::disabled\n", Data: logrus.Fields{"raw_output": true}},
+				{Level: logrus.ErrorLevel, Message: "enabled"},
+			},
+			expected: []protocol.Issue{{Type: "error", Category: "General", Message: "enabled", Data: map[string]string{"file": "b.go"}}},
+		},
+		{
+			name:    "raw output",
+			output:  "::notice::not a command\n",
+			entries: []*logrus.Entry{{Level: logrus.InfoLevel, Message: "  ❓  ::notice::not a command\n", Data: logrus.Fields{"raw_output": true}}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			jlogger := &logger.JobLogger{TimelineRecords: &protocol.TimelineRecordWrapper{}}
+			rec := jlogger.Append(protocol.CreateTimelineEntry("", "step", "Step"))
+			f := &ghaFormatter{logger: jlogger}
+			var w io.Writer = &commandRecorder{out: &actWriter{f: f, entries: tc.entries}, f: f}
+			_, err := w.Write([]byte(tc.output))
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, rec.Issues)
+			assert.Empty(t, f.commands)
+		})
+	}
+}
+
+func TestCommandRecorderPartialLines(t *testing.T) {
+	jlogger := &logger.JobLogger{TimelineRecords: &protocol.TimelineRecordWrapper{}}
+	rec := jlogger.Append(protocol.CreateTimelineEntry("", "step", "Step"))
+	f := &ghaFormatter{logger: jlogger}
+	out := &actWriter{f: f}
+	w := &commandRecorder{out: out, f: f}
+	_, _ = w.Write([]byte("::error file=a"))
+	out.entries = []*logrus.Entry{{Level: logrus.ErrorLevel, Message: "failed"}}
+	_, _ = w.Write([]byte(".go::failed\n"))
+	assert.Equal(t, []protocol.Issue{{Type: "error", Category: "General", Message: "failed", Data: map[string]string{"file": "a.go"}}}, rec.Issues)
+}
+
+// countingLogWriterContainer counts how often the log writer of the job container is replaced
+type countingLogWriterContainer struct {
+	*container.HostEnvironment
+	replaced int
+}
+
+func (c *countingLogWriterContainer) ReplaceLogWriter(stdout io.Writer, stderr io.Writer) (io.Writer, io.Writer) {
+	c.replaced++
+	return c.HostEnvironment.ReplaceLogWriter(stdout, stderr)
+}
+
+func TestRecordCommandsOncePerStep(t *testing.T) {
+	jlogger := &logger.JobLogger{TimelineRecords: &protocol.TimelineRecordWrapper{}}
+	jlogger.Append(protocol.CreateTimelineEntry("", "step", "Step"))
+	jobContainer := &countingLogWriterContainer{HostEnvironment: &container.HostEnvironment{StdOut: io.Discard}}
+	f := &ghaFormatter{rqt: &protocol.AgentJobRequestMessage{}, logger: jlogger, rc: &runner.RunContext{JobContainer: jobContainer}, main: true}
+	for _, entry := range []*logrus.Entry{
+		{Level: logrus.InfoLevel, Message: "⭐ Run Main step", Data: logrus.Fields{"stepID": []string{"step"}}},
+		{Level: logrus.InfoLevel, Message: "hello", Data: logrus.Fields{"stepID": []string{"step"}, "raw_output": true}},
+		{Level: logrus.WarnLevel, Message: "warning", Data: logrus.Fields{"stepID": []string{"step"}}},
+		{Level: logrus.InfoLevel, Message: "  ⚙  ::add-mask::***", Data: logrus.Fields{"stepID": []string{"step"}}},
+	} {
+		_, err := f.Format(entry)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 2, jobContainer.replaced, "the log writer is wrapped once when the step starts")
+	stdout, _ := jobContainer.HostEnvironment.ReplaceLogWriter(nil, nil)
+	assert.IsType(t, &commandRecorder{}, stdout)
+}
@@ -0,0 +1,119 @@
+package actionsdotnetactcompat
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/ChristopherHX/github-act-runner/actionsrunner"
+	"github.com/ChristopherHX/github-act-runner/protocol"
+	"github.com/ChristopherHX/github-act-runner/protocol/logger"
+	"github.com/sirupsen/logrus"
+)
+
+// Environment variables with the path of a job hook script, the same as in actions/runner
+const (
+	JobStartedHookEnv   = "ACTIONS_RUNNER_HOOK_JOB_STARTED"
+	JobCompletedHookEnv = "ACTIONS_RUNNER_HOOK_JOB_COMPLETED"
+)
+
+// lookupJobHook returns the script of a job hook, the env of the execution profile takes precedence over the environment of the runner
+func lookupJobHook(wc actionsrunner.WorkerContext, name string) string {
+	if profile := wc.Profile(); profile != nil {
+		if script, ok := profile.Env[name]; ok {
+			return script
+		}
+	}
+	return os.Getenv(name)
+}
+
+// jobHookCommand returns the command line to run script, like actions/runner only bash and powershell scripts are supported
+func jobHookCommand(script string) ([]string, error) {
+	switch strings.ToLower(filepath.Ext(script)) {
+	case ".sh":
+		shell := "bash"
+		if _, err := exec.LookPath(shell); err != nil {
+			shell = "sh"
+		}
+		return []string{shell, "-e", script}, nil
+	case ".ps1":
+		shell := "pwsh"
+		if _, err := exec.LookPath(shell); err != nil {
+			shell = "powershell"
+		}
+		return []string{shell, "-NoProfile", "-NonInteractive", "-ExecutionPolicy", "Bypass", "-File", script}, nil
+	}
+	return nil, fmt.Errorf("unsupported job hook %v, only .sh and .ps1 scripts are supported", script)
+}
+
+// jobHookEnviron adds the env of the job and the string values of the github context as GITHUB_* variables to the environment of the runner
+func jobHookEnviron(env map[string]string, githubCtx map[string]interface{}) []string {
+	environ := os.Environ()
+	for k, v := range env {
+		environ = append(environ, k+"="+v)
+	}
+	for k, v := range githubCtx {
+		if s, ok := v.(string); ok && k != "token" {
+			environ = append(environ, "GITHUB_"+strings.ToUpper(k)+"="+s)
+		}
+	}
+	return environ
+}
+
+// startJobRecord completes the current timeline record and starts a new one right after it, e.g. for a job hook
+func startJobRecord(jlogger *logger.JobLogger, refName string, name string) *protocol.TimelineRecord {
+	if cur := jlogger.Current(); cur != nil {
+		if cur.Result == nil {
+			cur.Complete("Succeeded")
+		}
+		jlogger.MoveNextExt(false)
+	}
+	te := protocol.CreateTimelineEntry(jlogger.TimelineRecords.Value[0].ID, refName, name)
+	te.Order = jlogger.TimelineRecords.Value[jlogger.CurrentRecord-1].Order + 1
+	rec := jlogger.Insert(te)
+	rec.Start()
+	jlogger.Update()
+	return rec
+}
+
+// runJobHook runs script in dir and logs its output to the current timeline record
+func runJobHook(ctx context.Context, logger *logrus.Logger, script string, dir string, environ []string) error {
+	args, err := jobHookCommand(script)
+	if err != nil {
+		return err
+	}
+	logger.Infof("A job hook has been configured by the self-hosted runner administrator: %v", script)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = dir
+	cmd.Env = environ
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+		pw.Close()
+	}()
+	output := bufio.NewReader(pr)
+	for {
+		line, err := output.ReadString('\n')
+		if len(line) > 0 {
+			logger.Info(strings.TrimRight(line, "\r\n"))
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err := <-done; err != nil {
+		return fmt.Errorf("job hook %v failed: %w", script, err)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package actionsdotnetactcompat
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ChristopherHX/github-act-runner/actionsrunner"
+	"github.com/ChristopherHX/github-act-runner/protocol"
+	"github.com/ChristopherHX/github-act-runner/protocol/fakeservice"
+	"github.com/ChristopherHX/github-act-runner/protocol/logger"
+	"github.com/ChristopherHX/github-act-runner/runnerconfiguration"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookupJobHook(t *testing.T) {
+	t.Setenv(JobStartedHookEnv, "/runner/started.sh")
+	t.Setenv(JobCompletedHookEnv, "/runner/completed.sh")
+	profile := &runnerconfiguration.ExecutionProfile{Env: map[string]string{JobStartedHookEnv: "/profile/started.sh"}}
+
+	assert.Equal(t, "/runner/started.sh", lookupJobHook(&actionsrunner.DefaultWorkerContext{}, JobStartedHookEnv))
+	wc := &actionsrunner.DefaultWorkerContext{ExecutionProfile: profile}
+	assert.Equal(t, "/profile/started.sh", lookupJobHook(wc, JobStartedHookEnv))
+	assert.Equal(t, "/runner/completed.sh", lookupJobHook(wc, JobCompletedHookEnv))
+
+	// An empty value of the profile disables the hook of the runner
+	profile.Env[JobCompletedHookEnv] = ""
+	assert.Equal(t, "", lookupJobHook(wc, JobCompletedHookEnv))
+}
+
+func TestJobHookCommand(t *testing.T) {
+	for _, tc := range []struct {
+		script string
+		shells []string
+		args   []string
+		err    string
+	}{
+		{script: "/hooks/started.sh", shells: []string{"bash", "sh"}, args: []string{"-e", "/hooks/started.sh"}},
+		{script: "/hooks/STARTED.SH", shells: []string{"bash", "sh"}, args: []string{"-e", "/hooks/STARTED.SH"}},
+		{script: "C:\\hooks\\started.ps1", shells: []string{"pwsh", "powershell"}, args: []string{"-NoProfile", "-NonInteractive", "-ExecutionPolicy", "Bypass", "-File", "C:\\hooks\\started.ps1"}},
+		{script: "/hooks/started.py", err: "unsupported job hook /hooks/started.py, only .sh and .ps1 scripts are supported"},
+		{script: "/hooks/started", err: "unsupported job hook /hooks/started, only .sh and .ps1 scripts are supported"},
+	} {
+		t.Run(tc.script, func(t *testing.T) {
+			args, err := jobHookCommand(tc.script)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			assert.NoError(t, err)
+			if assert.NotEmpty(t, args) {
+				assert.Contains(t, tc.shells, args[0])
+				assert.Equal(t, tc.args, args[1:])
+			}
+		})
+	}
+}
+
+func TestJobHookEnviron(t *testing.T) {
+	t.Setenv("RUNNER_HOOK_TEST", "runner")
+	environ := jobHookEnviron(map[string]string{"JOB_ENV": "job"}, map[string]interface{}{
+		"repository": "owner/repo",
+		"run_id":     "42",
+		"token":      "secret",
+		"event":      map[string]interface{}{"ref": "main"},
+	})
+	assert.Contains(t, environ, "RUNNER_HOOK_TEST=runner")
+	assert.Contains(t, environ, "JOB_ENV=job")
+	assert.Contains(t, environ, "GITHUB_REPOSITORY=owner/repo")
+	assert.Contains(t, environ, "GITHUB_RUN_ID=42")
+	for _, kv := range environ {
+		assert.False(t, strings.HasPrefix(kv, "GITHUB_TOKEN=secret"), "the token must not be exposed to the hook")
+		assert.False(t, strings.HasPrefix(kv, "GITHUB_EVENT="), "only string values are exposed to the hook")
+	}
+}
+
+func TestStartJobRecord(t *testing.T) {
+	server := fakeservice.NewServer()
+	defer server.Close()
+	jobreq := server.NewJob("build")
+	con, _, err := jobreq.GetConnection("SystemVssConnection")
+	if err != nil {
+		t.Fatal(err)
+	}
+	jlogger := &logger.JobLogger{
+		JobRequest:      jobreq,
+		Connection:      con,
+		TimelineRecords: &protocol.TimelineRecordWrapper{},
+	}
+	job := jlogger.Append(protocol.CreateTimelineEntry("", jobreq.JobName, jobreq.JobDisplayName))
+	job.ID = jobreq.JobID
+	jlogger.MoveNext()
+	setup := jlogger.Append(protocol.CreateTimelineEntry(jobreq.JobID, "__setup", "Set up Job"))
+	setup.Start()
+	step := jlogger.Append(protocol.CreateTimelineEntry(jobreq.JobID, "step", "Step"))
+
+	hook := startJobRecord(jlogger, "__job_started_hook", "Set up runner")
+	assert.Equal(t, "Succeeded", *setup.Result)
+	assert.Equal(t, hook, jlogger.Current())
+	hook.Complete("Succeeded")
+	next := startJobRecord(jlogger, "__setup_act", "Set up nektos/act")
+	assert.Equal(t, next, jlogger.Current(), "the remaining setup is logged to its own record")
+	assert.Nil(t, next.Result)
+
+	assert.Equal(t, []*protocol.TimelineRecord{job, setup, hook, next, step}, jlogger.TimelineRecords.Value)
+	assert.Less(t, setup.Order, hook.Order)
+	assert.Less(t, hook.Order, next.Order)
+}
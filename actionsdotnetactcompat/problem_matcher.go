@@ -0,0 +1,330 @@
+package actionsdotnetactcompat
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/ChristopherHX/github-act-runner/protocol"
+	"github.com/nektos/act/pkg/container"
+	"github.com/sirupsen/logrus"
+)
+
+// maxIssuesPerType limits the errors and warnings of a single step like actions/runner does
+const maxIssuesPerType = 10
+
+var (
+	// act logs both commands instead of handling them
+	addMatcherMessage    = regexp.MustCompile(`^\s*\x{2753} add-matcher (.+)$`)
+	removeMatcherMessage = regexp.MustCompile(`^\s*\x{2753}\s+::remove-matcher (?:.*,)?owner=([^,:]+)(?:,[^:]*)?::`)
+)
+
+// problemMatcherFile is the content of a file passed to ::add-matcher::, see https://github.com/actions/toolkit/blob/main/docs/problem-matchers.md
+type problemMatcherFile struct {
+	ProblemMatcher []*problemMatcher `json:"problemMatcher"`
+}
+
+// problemMatcher turns log lines of a step into issues of its timeline record
+type problemMatcher struct {
+	Owner    string            `json:"owner"`
+	Severity string            `json:"severity"`
+	Pattern  []*problemPattern `json:"pattern"`
+
+	// next is the index of the pattern matching the next line of a multi line problem
+	next int
+	data map[string]string
+}
+
+type problemPattern struct {
+	Regexp   string `json:"regexp"`
+	File     int    `json:"file"`
+	FromPath int    `json:"fromPath"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	EndLine  int    `json:"endLine"`
+	EndCol   int    `json:"endColumn"`
+	Location int    `json:"location"`
+	Severity int    `json:"severity"`
+	Code     int    `json:"code"`
+	Message  int    `json:"message"`
+	Loop     bool   `json:"loop"`
+
+	re *regexp.Regexp
+}
+
+// parseProblemMatchers validates and compiles the matchers of a problem matcher file
+func parseProblemMatchers(content []byte) ([]*problemMatcher, error) {
+	file := &problemMatcherFile{}
+	if err := json.Unmarshal(content, file); err != nil {
+		return nil, err
+	}
+	for _, matcher := range file.ProblemMatcher {
+		if matcher.Owner == "" {
+			return nil, fmt.Errorf("problem matcher without an owner")
+		}
+		if len(matcher.Pattern) == 0 {
+			return nil, fmt.Errorf("problem matcher %v has no pattern", matcher.Owner)
+		}
+		for i, pattern := range matcher.Pattern {
+			re, err := regexp.Compile(pattern.Regexp)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regexp of problem matcher %v: %w", matcher.Owner, err)
+			}
+			pattern.re = re
+			if pattern.Loop && i != len(matcher.Pattern)-1 {
+				return nil, fmt.Errorf("only the last pattern of problem matcher %v can loop", matcher.Owner)
+			}
+		}
+		if !hasMessage(matcher.Pattern) {
+			return nil, fmt.Errorf("problem matcher %v doesn't capture a message", matcher.Owner)
+		}
+	}
+	return file.ProblemMatcher, nil
+}
+
+func hasMessage(patterns []*problemPattern) bool {
+	for _, pattern := range patterns {
+		if pattern.Message != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Match returns the data of a problem if line completes one
+func (m *problemMatcher) Match(line string) map[string]string {
+	if m.next > 0 {
+		pattern := m.Pattern[m.next]
+		if groups := pattern.re.FindStringSubmatch(line); groups != nil {
+			data := m.data
+			if pattern.Loop {
+				// Every match of a looping pattern is a problem sharing the data of the previous patterns
+				data = copyMap(m.data)
+			}
+			pattern.collect(groups, data)
+			if m.next == len(m.Pattern)-1 {
+				if !pattern.Loop {
+					m.reset()
+				}
+				return data
+			}
+			m.next++
+			return nil
+		}
+		m.reset()
+	}
+	pattern := m.Pattern[0]
+	groups := pattern.re.FindStringSubmatch(line)
+	if groups == nil {
+		return nil
+	}
+	data := map[string]string{}
+	pattern.collect(groups, data)
+	if len(m.Pattern) == 1 {
+		return data
+	}
+	m.next = 1
+	m.data = data
+	return nil
+}
+
+func (m *problemMatcher) reset() {
+	m.next = 0
+	m.data = nil
+}
+
+func (p *problemPattern) collect(groups []string, data map[string]string) {
+	set := func(name string, index int) {
+		if index > 0 && index < len(groups) && groups[index] != "" {
+			data[name] = strings.TrimSpace(groups[index])
+		}
+	}
+	set("file", p.File)
+	set("fromPath", p.FromPath)
+	set("line", p.Line)
+	set("col", p.Column)
+	set("endLine", p.EndLine)
+	set("endColumn", p.EndCol)
+	set("severity", p.Severity)
+	set("code", p.Code)
+	set("message", p.Message)
+	if p.Location > 0 && p.Location < len(groups) {
+		// line, line,col or line,col,endLine,endColumn
+		for i, v := range strings.Split(groups[p.Location], ",") {
+			if i < 4 && strings.TrimSpace(v) != "" {
+				data[[]string{"line", "col", "endLine", "endColumn"}[i]] = strings.TrimSpace(v)
+			}
+		}
+	}
+}
+
+func copyMap(src map[string]string) map[string]string {
+	dst := make(map[string]string, len(src))
+	for k, v := range src {
+		dst[k] = v
+	}
+	return dst
+}
+
+// toIssue converts the data of a problem to an issue, file paths inside of workspace become relative to it
+func (m *problemMatcher) toIssue(data map[string]string, workspace string) (protocol.Issue, bool) {
+	message := data["message"]
+	if message == "" {
+		return protocol.Issue{}, false
+	}
+	issue := protocol.Issue{
+		Type:     issueType(data["severity"], m.Severity),
+		Category: "Code",
+		Message:  message,
+		Data:     map[string]string{},
+	}
+	for _, k := range []string{"line", "col", "endLine", "endColumn", "code"} {
+		if v, ok := data[k]; ok {
+			issue.Data[k] = v
+		}
+	}
+	if file := strings.ReplaceAll(data["file"], "\\", "/"); file != "" {
+		if fromPath := strings.ReplaceAll(data["fromPath"], "\\", "/"); fromPath != "" && !path.IsAbs(file) {
+			file = path.Join(path.Dir(fromPath), file)
+		}
+		if prefix := strings.TrimSuffix(strings.ReplaceAll(workspace, "\\", "/"), "/") + "/"; workspace != "" && strings.HasPrefix(file, prefix) {
+			file = strings.TrimPrefix(file, prefix)
+		}
+		issue.Data["file"] = path.Clean(file)
+	}
+	return issue, true
+}
+
+// issueType maps the severity of a problem to error, warning or notice
+func issueType(severity string, defaultSeverity string) string {
+	for _, s := range []string{severity, defaultSeverity} {
+		s = strings.ToLower(s)
+		switch {
+		case strings.HasPrefix(s, "warn"):
+			return "warning"
+		case s == "notice" || s == "info":
+			return "notice"
+		case s == "error":
+			return "error"
+		}
+	}
+	return "error"
+}
+
+// addIssue adds issue to the timeline record, issues exceeding maxIssuesPerType are dropped
+func addIssue(rec *protocol.TimelineRecord, issue protocol.Issue) {
+	switch issue.Type {
+	case "error":
+		if rec.ErrorCount >= maxIssuesPerType {
+			return
+		}
+		rec.ErrorCount++
+	case "warning":
+		if rec.WarningCount >= maxIssuesPerType {
+			return
+		}
+		rec.WarningCount++
+	}
+	rec.Issues = append(rec.Issues, issue)
+}
+
+// readContainerFile reads a file from the job container or the host
+func readContainerFile(ctx context.Context, env container.ExecutionsEnvironment, file string) ([]byte, error) {
+	archive, err := env.GetContainerArchive(ctx, file)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+	tr := tar.NewReader(archive)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			return nil, fmt.Errorf("%v is not a file: %w", file, err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			return io.ReadAll(tr)
+		}
+	}
+}
+
+// readMatcherFile reads a problem matcher file through the job container, or from the host if there is none
+func (f *ghaFormatter) readMatcherFile(file string) ([]byte, error) {
+	if f.rc.JobContainer == nil {
+		return os.ReadFile(file)
+	}
+	ctx := f.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return readContainerFile(ctx, f.rc.JobContainer, file)
+}
+
+// matchProblems handles the add-matcher and remove-matcher commands and applies the problem matchers to the output of steps.
+// It returns warnings to add to the log, the logger can't be used while formatting
+func (f *ghaFormatter) matchProblems(entry *logrus.Entry) []string {
+	if f.rc == nil || f.rc.Config == nil {
+		return nil
+	}
+	workspace := f.rc.Config.Workdir
+	if f.rc.JobContainer != nil {
+		workspace = f.rc.JobContainer.ToContainerPath(workspace)
+	}
+	if m := addMatcherMessage.FindStringSubmatch(entry.Message); m != nil {
+		file := strings.TrimSpace(m[1])
+		if !path.IsAbs(file) && !filepath.IsAbs(file) {
+			file = path.Join(workspace, file)
+		}
+		content, err := f.readMatcherFile(file)
+		if err == nil {
+			var matchers []*problemMatcher
+			if matchers, err = parseProblemMatchers(content); err == nil {
+				for _, matcher := range matchers {
+					f.removeMatcher(matcher.Owner)
+					f.matchers = append(f.matchers, matcher)
+				}
+				return nil
+			}
+		}
+		return []string{fmt.Sprintf("Failed to add the problem matcher %v: %v", file, err)}
+	}
+	if m := removeMatcherMessage.FindStringSubmatch(entry.Message); m != nil {
+		f.removeMatcher(m[1])
+		return nil
+	}
+	cur := f.logger.Current()
+	if entry.Data["raw_output"] != true || cur == nil || len(f.matchers) == 0 {
+		return nil
+	}
+	line := strings.TrimRight(entry.Message, "\r\n")
+	for _, matcher := range f.matchers {
+		if data := matcher.Match(line); data != nil {
+			if issue, ok := matcher.toIssue(data, workspace); ok {
+				addIssue(cur, issue)
+			}
+			// Like in actions/runner a line is only reported once
+			for _, other := range f.matchers {
+				if other != matcher {
+					other.reset()
+				}
+			}
+			break
+		}
+	}
+	return nil
+}
+
+func (f *ghaFormatter) removeMatcher(owner string) {
+	for i, matcher := range f.matchers {
+		if strings.EqualFold(matcher.Owner, owner) {
+			f.matchers = append(f.matchers[:i], f.matchers[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package actionsdotnetactcompat
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ChristopherHX/github-act-runner/protocol"
+	"github.com/ChristopherHX/github-act-runner/protocol/logger"
+	"github.com/nektos/act/pkg/runner"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+const eslintMatcher = `{"problemMatcher": [{
+	"owner": "eslint-stylish",
+	"pattern": [
+		{"regexp": "^([^\\s].*)$", "file": 1},
+		{"regexp": "^\\s+(\\d+):(\\d+)\\s+(error|warning)\\s+(.*)\\s\\s+(.*)$", "line": 1, "column": 2, "severity": 3, "message": 4, "code": 5, "loop": true}
+	]
+}]}`
+
+func TestParseProblemMatchers(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content string
+		owners  []string
+		err     string
+	}{
+		{"multi line loop", eslintMatcher, []string{"eslint-stylish"}, ""},
+		{"multiple matchers", `{"problemMatcher": [{"owner": "a", "pattern": [{"regexp": "(.*)", "message": 1}]}, {"owner": "b", "pattern": [{"regexp": "(.*)", "message": 1}]}]}`, []string{"a", "b"}, ""},
+		{"invalid json", `{`, nil, "unexpected end of JSON input"},
+		{"missing owner", `{"problemMatcher": [{"pattern": [{"regexp": "(.*)", "message": 1}]}]}`, nil, "problem matcher without an owner"},
+		{"missing pattern", `{"problemMatcher": [{"owner": "a"}]}`, nil, "problem matcher a has no pattern"},
+		{"invalid regexp", `{"problemMatcher": [{"owner": "a", "pattern": [{"regexp": "(", "message": 1}]}]}`, nil, "invalid regexp of problem matcher a: error parsing regexp: missing closing ): `(`"},
+		{"loop before the last pattern", `{"problemMatcher": [{"owner": "a", "pattern": [{"regexp": "(.*)", "loop": true}, {"regexp": "(.*)", "message": 1}]}]}`, nil, "only the last pattern of problem matcher a can loop"},
+		{"missing message", `{"problemMatcher": [{"owner": "a", "pattern": [{"regexp": "(.*)", "file": 1}]}]}`, nil, "problem matcher a doesn't capture a message"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			matchers, err := parseProblemMatchers([]byte(tc.content))
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			assert.NoError(t, err)
+			owners := []string{}
+			for _, matcher := range matchers {
+				owners = append(owners, matcher.Owner)
+			}
+			assert.Equal(t, tc.owners, owners)
+		})
+	}
+}
+
+func TestProblemMatcherMatch(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		matcher  string
+		lines    []string
+		expected []map[string]string
+	}{
+		{
+			name:    "single line",
+			matcher: `{"problemMatcher": [{"owner": "go", "pattern": [{"regexp": "^(.+):(\\d+):(\\d+): (.+)$", "file": 1, "line": 2, "column": 3, "message": 4}]}]}`,
+			lines:   []string{"ok", "main.go:3:5: undefined: x"},
+			expected: []map[string]string{
+				{"file": "main.go", "line": "3", "col": "5", "message": "undefined: x"},
+			},
+		},
+		{
+			name:    "location",
+			matcher: `{"problemMatcher": [{"owner": "a", "pattern": [{"regexp": "^(.+)\\((.+)\\): (.+)$", "file": 1, "location": 2, "message": 3}]}]}`,
+			lines:   []string{"a.cs(1,2,3,4): bad"},
+			expected: []map[string]string{
+				{"file": "a.cs", "line": "1", "col": "2", "endLine": "3", "endColumn": "4", "message": "bad"},
+			},
+		},
+		{
+			name:    "multi line loop",
+			matcher: eslintMatcher,
+			lines: []string{
+				"src/a.js",
+				"  1:2  error  Missing semicolon  semi",
+				"  3:4  warning  Unexpected console  no-console",
+				"",
+				"  5:6  error  Not reported  semi",
+			},
+			expected: []map[string]string{
+				{"file": "src/a.js", "line": "1", "col": "2", "severity": "error", "message": "Missing semicolon", "code": "semi"},
+				{"file": "src/a.js", "line": "3", "col": "4", "severity": "warning", "message": "Unexpected console", "code": "no-console"},
+			},
+		},
+		{
+			name:    "multi line restarts",
+			matcher: `{"problemMatcher": [{"owner": "a", "pattern": [{"regexp": "^file (.+)$", "file": 1}, {"regexp": "^message (.+)$", "message": 1}]}]}`,
+			lines:   []string{"file a", "other", "message lost", "file b", "message found", "message lost"},
+			expected: []map[string]string{
+				{"file": "b", "message": "found"},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			matchers, err := parseProblemMatchers([]byte(tc.matcher))
+			if !assert.NoError(t, err) {
+				return
+			}
+			var problems []map[string]string
+			for _, line := range tc.lines {
+				if data := matchers[0].Match(line); data != nil {
+					problems = append(problems, data)
+				}
+			}
+			assert.Equal(t, tc.expected, problems)
+		})
+	}
+}
+
+func TestProblemMatcherToIssue(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		severity  string
+		data      map[string]string
+		workspace string
+		expected  *protocol.Issue
+	}{
+		{
+			name:      "file in workspace",
+			data:      map[string]string{"file": "/work/repo/src/a.go", "line": "1", "col": "2", "message": "bad", "code": "E1", "severity": "warning"},
+			workspace: "/work/repo",
+			expected:  &protocol.Issue{Type: "warning", Category: "Code", Message: "bad", Data: map[string]string{"file": "src/a.go", "line": "1", "col": "2", "code": "E1"}},
+		},
+		{
+			name:      "file relative to fromPath",
+			severity:  "notice",
+			data:      map[string]string{"file": "b.go", "fromPath": "pkg\\build.log", "message": "info"},
+			workspace: "C:\\work\\repo",
+			expected:  &protocol.Issue{Type: "notice", Category: "Code", Message: "info", Data: map[string]string{"file": "pkg/b.go"}},
+		},
+		{
+			name:      "windows workspace",
+			data:      map[string]string{"file": "C:\\work\\repo\\a.go", "message": "bad", "severity": "fatal"},
+			workspace: "C:\\work\\repo\\",
+			expected:  &protocol.Issue{Type: "error", Category: "Code", Message: "bad", Data: map[string]string{"file": "a.go"}},
+		},
+		{
+			name:     "default severity",
+			severity: "warning",
+			data:     map[string]string{"message": "bad", "severity": "unknown"},
+			expected: &protocol.Issue{Type: "warning", Category: "Code", Message: "bad", Data: map[string]string{}},
+		},
+		{
+			name: "without message",
+			data: map[string]string{"file": "a.go"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			issue, ok := (&problemMatcher{Severity: tc.severity}).toIssue(tc.data, tc.workspace)
+			if tc.expected == nil {
+				assert.False(t, ok)
+				return
+			}
+			assert.True(t, ok)
+			assert.Equal(t, *tc.expected, issue)
+		})
+	}
+}
+
+func TestAddIssueLimit(t *testing.T) {
+	rec := &protocol.TimelineRecord{}
+	for i := 0; i < maxIssuesPerType+2; i++ {
+		addIssue(rec, protocol.Issue{Type: "error"})
+		addIssue(rec, protocol.Issue{Type: "notice"})
+	}
+	assert.Equal(t, maxIssuesPerType, rec.ErrorCount)
+	assert.Len(t, rec.Issues, maxIssuesPerType+maxIssuesPerType+2)
+}
+
+func TestMatchProblemsWithoutJobContainer(t *testing.T) {
+	workdir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(workdir, "matcher.json"), []byte(eslintMatcher), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	jlogger := &logger.JobLogger{TimelineRecords: &protocol.TimelineRecordWrapper{}}
+	rec := jlogger.Append(protocol.CreateTimelineEntry("", "step", "Step"))
+	f := &ghaFormatter{logger: jlogger, rc: &runner.RunContext{Config: &runner.Config{Workdir: workdir}}}
+
+	assert.Empty(t, f.matchProblems(&logrus.Entry{Message: "  \u2753 add-matcher matcher.json"}))
+	assert.Len(t, f.matchers, 1)
+	for _, line := range []string{filepath.Join(workdir, "a.js") + "\n", "  1:2  error  Missing semicolon  semi\n"} {
+		f.matchProblems(&logrus.Entry{Message: line, Data: logrus.Fields{"raw_output": true}})
+	}
+	assert.Equal(t, []protocol.Issue{{Type: "error", Category: "Code", Message: "Missing semicolon", Data: map[string]string{"file": "a.js", "line": "1", "col": "2", "code": "semi"}}}, rec.Issues)
+
+	assert.Empty(t, f.matchProblems(&logrus.Entry{Message: "  \u2753  ::remove-matcher owner=eslint-stylish::"}))
+	assert.Empty(t, f.matchers)
+
+	warnings := f.matchProblems(&logrus.Entry{Message: "  \u2753 add-matcher missing.json"})
+	assert.Len(t, warnings, 1)
+}
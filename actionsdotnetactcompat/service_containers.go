@@ -0,0 +1,182 @@
+//go:build !(WITHOUT_DOCKER || !(linux || darwin || windows))
+
+package actionsdotnetactcompat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	rcommon "github.com/ChristopherHX/github-act-runner/common"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"github.com/google/uuid"
+	"github.com/nektos/act/pkg/common"
+	"github.com/nektos/act/pkg/container"
+	"github.com/nektos/act/pkg/model"
+)
+
+// serviceContainers are the service containers of a job, they share a network with the job container
+type serviceContainers struct {
+	// Network is the name of the docker network, the service containers are reachable by their id inside of it
+	Network string
+	// Context is the value of job.services
+	Context map[string]interface{}
+
+	cli        client.APIClient
+	containers []container.ExecutionsEnvironment
+	serviceIDs map[string]bool
+	jobID      string
+}
+
+// startServiceContainers creates the network of the job and starts all services, it waits until their health checks pass.
+// The returned value has to be removed even if an error occurred
+func startServiceContainers(ctx context.Context, services map[string]*model.ContainerSpec, platform string) (*serviceContainers, error) {
+	logger := common.Logger(ctx)
+	id := strings.ReplaceAll(uuid.NewString(), "-", "")
+	sc := &serviceContainers{
+		Network:    "github_network_" + id,
+		Context:    map[string]interface{}{},
+		serviceIDs: map[string]bool{},
+	}
+	cli, err := container.GetDockerClient(ctx)
+	if err != nil {
+		return sc, err
+	}
+	sc.cli = cli
+	logger.Infof("Create the network %v", sc.Network)
+	if _, err := cli.NetworkCreate(ctx, sc.Network, types.NetworkCreate{CheckDuplicate: true}); err != nil {
+		sc.Network = ""
+		return sc, fmt.Errorf("failed to create the network of the service containers: %w", err)
+	}
+	for serviceID, spec := range services {
+		name := serviceID + "_" + id
+		// act uses the host network if the options don't specify one
+		options := "--network " + sc.Network + " " + spec.Options
+		for _, port := range spec.Ports {
+			options += " -p " + quoteOption(port)
+		}
+		for _, volume := range spec.Volumes {
+			options += " -v " + quoteOption(volume)
+		}
+		env := make([]string, 0, len(spec.Env))
+		for k, v := range spec.Env {
+			env = append(env, k+"="+v)
+		}
+		c := container.NewContainer(&container.NewContainerInput{
+			Image:       spec.Image,
+			Username:    spec.Credentials["username"],
+			Password:    spec.Credentials["password"],
+			Name:        name,
+			Env:         env,
+			NetworkMode: sc.Network,
+			Platform:    platform,
+			Options:     strings.TrimSpace(options),
+		})
+		logger.Infof("Start the service container %v", serviceID)
+		if err := common.NewPipelineExecutor(c.Pull(false), c.Create(nil, nil))(ctx); err != nil {
+			return sc, fmt.Errorf("failed to create the service container %v: %w", serviceID, err)
+		}
+		sc.containers = append(sc.containers, c)
+		// Connect the container again to make it reachable by the id of the service
+		if err := cli.NetworkDisconnect(ctx, sc.Network, name, true); err != nil {
+			return sc, err
+		}
+		if err := cli.NetworkConnect(ctx, sc.Network, name, &network.EndpointSettings{Aliases: []string{serviceID}}); err != nil {
+			return sc, err
+		}
+		if err := c.Start(false)(ctx); err != nil {
+			return sc, fmt.Errorf("failed to start the service container %v: %w", serviceID, err)
+		}
+	}
+	for serviceID := range services {
+		info, err := sc.waitForHealthy(ctx, serviceID+"_"+id)
+		if err != nil {
+			return sc, fmt.Errorf("service container %v failed: %w", serviceID, err)
+		}
+		ports := map[string]interface{}{}
+		if info.NetworkSettings != nil {
+			for port, bindings := range info.NetworkSettings.Ports {
+				if len(bindings) > 0 {
+					ports[port.Port()] = bindings[0].HostPort
+				}
+			}
+		}
+		sc.serviceIDs[info.ID] = true
+		sc.Context[serviceID] = map[string]interface{}{
+			"id":      info.ID,
+			"network": sc.Network,
+			"ports":   ports,
+		}
+	}
+	return sc, nil
+}
+
+// waitForHealthy waits until the health check of the container passes, containers without a health check are healthy once started
+func (sc *serviceContainers) waitForHealthy(ctx context.Context, name string) (types.ContainerJSON, error) {
+	logger := common.Logger(ctx)
+	backoff := &rcommon.Backoff{Min: time.Second, Max: 30 * time.Second}
+	for {
+		info, err := sc.cli.ContainerInspect(ctx, name)
+		if err != nil {
+			return info, err
+		}
+		if info.State == nil || info.State.Health == nil || info.State.Health.Status == types.Healthy {
+			return info, nil
+		}
+		if info.State.Health.Status == types.Unhealthy {
+			if l := len(info.State.Health.Log); l > 0 {
+				logger.Errorf("Health check output: %v", strings.TrimSpace(info.State.Health.Log[l-1].Output))
+			}
+			return info, fmt.Errorf("the health check failed")
+		}
+		delay := backoff.Next()
+		logger.Infof("Waiting for the service container %v to become healthy, retry in %v", name, delay)
+		select {
+		case <-ctx.Done():
+			return info, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// JobContainerID returns the id of the job container, it is the only container of the network which isn't a service container
+func (sc *serviceContainers) JobContainerID(ctx context.Context) (string, error) {
+	if sc.jobID != "" || sc.cli == nil || sc.Network == "" {
+		return sc.jobID, nil
+	}
+	info, err := sc.cli.NetworkInspect(ctx, sc.Network, types.NetworkInspectOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect the network %v: %w", sc.Network, err)
+	}
+	for id := range info.Containers {
+		if !sc.serviceIDs[id] {
+			sc.jobID = id
+		}
+	}
+	return sc.jobID, nil
+}
+
+// Remove removes all service containers and the network, it tries to remove all of them even if one fails
+func (sc *serviceContainers) Remove(ctx context.Context) error {
+	var errs []error
+	for _, c := range sc.containers {
+		if err := c.Remove()(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	sc.containers = nil
+	if sc.cli == nil {
+		return errors.Join(errs...)
+	}
+	defer sc.cli.Close()
+	if sc.Network != "" {
+		if err := sc.cli.NetworkRemove(ctx, sc.Network); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove the network %v: %w", sc.Network, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,28 @@
+//go:build WITHOUT_DOCKER || !(linux || darwin || windows)
+
+package actionsdotnetactcompat
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nektos/act/pkg/model"
+)
+
+// serviceContainers are not supported without docker
+type serviceContainers struct {
+	Network string
+	Context map[string]interface{}
+}
+
+func startServiceContainers(ctx context.Context, services map[string]*model.ContainerSpec, platform string) (*serviceContainers, error) {
+	return &serviceContainers{}, fmt.Errorf("service containers require docker, which is not available in this build of the runner")
+}
+
+func (sc *serviceContainers) Remove(ctx context.Context) error {
+	return nil
+}
+
+func (sc *serviceContainers) JobContainerID(ctx context.Context) (string, error) {
+	return "", nil
+}
@@ -0,0 +1,63 @@
+//go:build !(WITHOUT_DOCKER || !(linux || darwin || windows))
+
+package actionsdotnetactcompat
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/docker/docker/client"
+	"github.com/nektos/act/pkg/common"
+	"github.com/nektos/act/pkg/container"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeServiceContainer struct {
+	container.ExecutionsEnvironment
+	name    string
+	removed *[]string
+	err     error
+}
+
+func (c *fakeServiceContainer) Remove() common.Executor {
+	return func(ctx context.Context) error {
+		*c.removed = append(*c.removed, c.name)
+		return c.err
+	}
+}
+
+type fakeDockerClient struct {
+	client.APIClient
+	removed *[]string
+	closed  bool
+}
+
+func (cli *fakeDockerClient) NetworkRemove(ctx context.Context, network string) error {
+	*cli.removed = append(*cli.removed, network)
+	return fmt.Errorf("network in use")
+}
+
+func (cli *fakeDockerClient) Close() error {
+	cli.closed = true
+	return nil
+}
+
+func TestRemoveServiceContainers(t *testing.T) {
+	removed := []string{}
+	cli := &fakeDockerClient{removed: &removed}
+	sc := &serviceContainers{
+		Network: "github_network_1",
+		cli:     cli,
+		containers: []container.ExecutionsEnvironment{
+			&fakeServiceContainer{name: "redis", removed: &removed, err: fmt.Errorf("redis is still running")},
+			&fakeServiceContainer{name: "db", removed: &removed},
+		},
+	}
+	err := sc.Remove(context.Background())
+	assert.ErrorContains(t, err, "redis is still running")
+	assert.ErrorContains(t, err, "failed to remove the network github_network_1: network in use")
+	assert.Equal(t, []string{"redis", "db", "github_network_1"}, removed, "a failure must not stop removing the others")
+	assert.True(t, cli.closed)
+	assert.Empty(t, sc.containers)
+}
@@ -1,36 +1,146 @@
 package actionsdotnetactcompat
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/ChristopherHX/github-act-runner/protocol"
 	"github.com/nektos/act/pkg/model"
+	"github.com/nektos/act/pkg/runner"
+	"gopkg.in/yaml.v3"
 )
 
-func ConvertServiceContainer(jobServiceContainers *protocol.TemplateToken) (map[string]*model.ContainerSpec, error) {
+// ConvertServiceContainer evaluates the expressions of the service containers of the job, services with an empty image are skipped like in actions/runner
+func ConvertServiceContainer(ctx context.Context, eval runner.ExpressionEvaluator, jobServiceContainers *protocol.TemplateToken) (map[string]*model.ContainerSpec, error) {
 	services := make(map[string]*model.ContainerSpec)
-	if jobServiceContainers != nil {
-		rawServiceContainer, ok := jobServiceContainers.ToRawObject().(map[interface{}]interface{})
-		if !ok {
-			return nil, fmt.Errorf("job service container is not nil, but also not a map")
+	if jobServiceContainers == nil {
+		return services, nil
+	}
+	node := jobServiceContainers.ToYamlNode()
+	if node == nil {
+		return nil, fmt.Errorf("failed to convert the job service containers")
+	}
+	if err := eval.EvaluateYamlNode(ctx, node); err != nil {
+		return nil, fmt.Errorf("failed to evaluate the job service containers: %w", err)
+	}
+	if node.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("job service container is not nil, but also not a map")
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		containerName := node.Content[i].Value
+		rawcontainer := node.Content[i+1]
+		spec := &model.ContainerSpec{}
+		var err error
+		switch rawcontainer.Kind {
+		case yaml.ScalarNode:
+			err = rawcontainer.Decode(&spec.Image)
+		case yaml.MappingNode:
+			err = rawcontainer.Decode(spec)
+		default:
+			err = fmt.Errorf("expected an image or a mapping")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to deserialize the service container %v: %w", containerName, err)
 		}
-		for name, rawcontainer := range rawServiceContainer {
-			containerName, ok := name.(string)
-			if !ok {
-				return nil, fmt.Errorf("containername is not a string")
+		if spec.Image == "" {
+			continue
+		}
+		services[containerName] = spec
+	}
+	return services, nil
+}
+
+// containerNetwork returns the network selected by the container options
+func containerNetwork(options string) string {
+	fields := strings.Fields(options)
+	for i, field := range fields {
+		for _, flag := range []string{"--network", "--net"} {
+			if field == flag && i+1 < len(fields) {
+				return strings.Trim(fields[i+1], `'"`)
 			}
-			spec := &model.ContainerSpec{}
-			b, err := json.Marshal(toStringMap(rawcontainer))
-			if err != nil {
-				return nil, fmt.Errorf("failed to serialize ContainerSpec")
+			if strings.HasPrefix(field, flag+"=") {
+				return strings.Trim(strings.TrimPrefix(field, flag+"="), `'"`)
 			}
-			err = json.Unmarshal(b, &spec)
-			if err != nil {
-				return nil, fmt.Errorf("failed to deserialize ContainerSpec")
+		}
+	}
+	return ""
+}
+
+// addContainerNetwork adds the network of the service containers to the options of the job container and returns the network of the job container.
+// act creates the job container in the host network, unless the options select a network. A network selected by the job is kept
+func addContainerNetwork(rawContainer *yaml.Node, network string) string {
+	option := "--network " + network
+	switch rawContainer.Kind {
+	case yaml.ScalarNode:
+		image := *rawContainer
+		*rawContainer = yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{
+			{Kind: yaml.ScalarNode, Value: "image"}, &image,
+			{Kind: yaml.ScalarNode, Value: "options"}, {Kind: yaml.ScalarNode, Value: option},
+		}}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(rawContainer.Content); i += 2 {
+			if rawContainer.Content[i].Value == "options" {
+				options := rawContainer.Content[i+1]
+				if selected := containerNetwork(options.Value); selected != "" {
+					return selected
+				}
+				options.Value = strings.TrimSpace(options.Value + " " + option)
+				options.Tag = "!!str"
+				return network
 			}
-			services[containerName] = spec
 		}
+		rawContainer.Content = append(rawContainer.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "options"}, &yaml.Node{Kind: yaml.ScalarNode, Value: option})
 	}
-	return services, nil
+	return network
+}
+
+// quoteOption quotes value for the container options, which are split like a shell command line
+func quoteOption(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// jobContext has the fields of the job context of act, which only sets job.status, and adds job.container and job.services
+type jobContext struct {
+	Status    jobStatus              `json:"status"`
+	Container jobContainerContext    `json:"container"`
+	Services  map[string]interface{} `json:"services"`
+}
+
+type jobContainerContext struct {
+	ID      jobContainerID `json:"id"`
+	Network string         `json:"network"`
+}
+
+// jobContainerID is looked up whenever job.container.id is read, act creates the job container after the job context has been set
+type jobContainerID struct {
+	services *serviceContainers
+}
+
+func (id jobContainerID) MarshalText() ([]byte, error) {
+	if id.services == nil {
+		return []byte{}, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	containerID, err := id.services.JobContainerID(ctx)
+	return []byte(containerID), err
+}
+
+// jobStatus is evaluated whenever job.status is read, like act does for its own job context
+type jobStatus struct {
+	rc *runner.RunContext
+}
+
+func (s jobStatus) MarshalText() ([]byte, error) {
+	if s.rc.Cancelled {
+		return []byte("cancelled"), nil
+	}
+	for _, stepResult := range s.rc.StepResults {
+		if stepResult.Conclusion == model.StepStatusFailure {
+			return []byte("failure"), nil
+		}
+	}
+	return []byte("success"), nil
 }
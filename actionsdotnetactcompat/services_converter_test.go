@@ -0,0 +1,112 @@
+package actionsdotnetactcompat
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nektos/act/pkg/model"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestConvertServiceContainer(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		services string
+		expected map[string]*model.ContainerSpec
+		err      string
+	}{
+		{
+			name:     "image",
+			services: "redis: redis:7",
+			expected: map[string]*model.ContainerSpec{"redis": {Image: "redis:7"}},
+		},
+		{
+			name:     "mapping",
+			services: "db:\n  image: postgres\n  env:\n    POSTGRES_PASSWORD: ${{ env.JOB_ENV }}\n  ports: ['5432']\n  options: --health-cmd pg_isready",
+			expected: map[string]*model.ContainerSpec{"db": {
+				Image:   "postgres",
+				Env:     map[string]string{"POSTGRES_PASSWORD": "job"},
+				Ports:   []string{"5432"},
+				Options: "--health-cmd pg_isready",
+			}},
+		},
+		{
+			name:     "empty image is skipped",
+			services: "redis: ${{ '' }}\ndb:\n  image: ''",
+			expected: map[string]*model.ContainerSpec{},
+		},
+		{
+			name:     "not a map",
+			services: "[redis]",
+			err:      "job service container is not nil, but also not a map",
+		},
+		{
+			name:     "invalid service",
+			services: "redis: [redis]",
+			err:      "failed to deserialize the service container redis: expected an image or a mapping",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			rc := newStepTestRunContext()
+			services, err := ConvertServiceContainer(ctx, rc.NewExpressionEvaluator(ctx), toTemplateToken(t, tc.services))
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, services)
+		})
+	}
+}
+
+func TestAddContainerNetwork(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		container string
+		expected  string
+		options   string
+	}{
+		{"image", "node:20", "github_network", "--network github_network"},
+		{"without options", "image: node:20", "github_network", "--network github_network"},
+		{"with options", "image: node:20\noptions: --cpus 1", "github_network", "--cpus 1 --network github_network"},
+		{"network option", "image: node:20\noptions: --cpus 1 --network=custom", "custom", "--cpus 1 --network=custom"},
+		{"net option", "image: node:20\noptions: --net 'custom'", "custom", "--net 'custom'"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			doc := &yaml.Node{}
+			if err := yaml.Unmarshal([]byte(tc.container), doc); err != nil {
+				t.Fatal(err)
+			}
+			node := doc.Content[0]
+			assert.Equal(t, tc.expected, addContainerNetwork(node, "github_network"))
+			container := &model.ContainerSpec{}
+			assert.NoError(t, node.Decode(container))
+			assert.Equal(t, "node:20", container.Image)
+			assert.Equal(t, tc.options, container.Options)
+		})
+	}
+}
+
+func TestQuoteOption(t *testing.T) {
+	assert.Equal(t, "'8080:80'", quoteOption("8080:80"))
+	assert.Equal(t, `'/a b:/it'\''s'`, quoteOption("/a b:/it's"))
+}
+
+func TestJobContext(t *testing.T) {
+	ctx := context.Background()
+	rc := newStepTestRunContext()
+	rc.ContextData["job"] = &jobContext{
+		Status:    jobStatus{rc: rc},
+		Container: jobContainerContext{Network: "github_network"},
+		Services:  map[string]interface{}{"redis": map[string]interface{}{"id": "abc", "ports": map[string]interface{}{"6379": "49153"}}},
+	}
+	ee := rc.NewExpressionEvaluator(ctx)
+	assert.Equal(t, "success github_network  abc 49153", ee.Interpolate(ctx, "${{ job.status }} ${{ job.container.network }} ${{ job.container.id }} ${{ job.services.redis.id }} ${{ job.services.redis.ports['6379'] }}"))
+
+	rc.StepResults["failed"] = &model.StepResult{Conclusion: model.StepStatusFailure}
+	assert.Equal(t, "failure", ee.Interpolate(ctx, "${{ job.status }}"))
+	rc.Cancelled = true
+	assert.Equal(t, "cancelled", ee.Interpolate(ctx, "${{ job.status }}"))
+}
@@ -1,147 +1,251 @@
 package actionsdotnetactcompat
 
 import (
+	"context"
 	"fmt"
+	"math"
+	"strconv"
 	"strings"
 
 	"github.com/ChristopherHX/github-act-runner/protocol"
 	"github.com/google/uuid"
 	"github.com/nektos/act/pkg/model"
+	"github.com/nektos/act/pkg/runner"
+	"github.com/rhysd/actionlint"
 	"gopkg.in/yaml.v3"
 )
 
-func ConvertSteps(jobSteps []protocol.ActionStep) ([]*model.Step, error) {
-	steps := []*model.Step{}
-	for _, step := range jobSteps {
-		st := strings.ToLower(step.Reference.Type)
-		inputs := make(map[interface{}]interface{})
-		if step.Inputs != nil {
-			if tmpinputs, ok := step.Inputs.ToRawObject().(map[interface{}]interface{}); ok {
-				inputs = tmpinputs
-			} else {
-				return nil, fmt.Errorf("step.Inputs: not a map")
+// canEvaluateNow returns false if the expression uses contexts, which are only known while the job runs
+func canEvaluateNow(expr string) bool {
+	parser := actionlint.NewExprParser()
+	exprNode, err := parser.Parse(actionlint.NewExprLexer(strings.TrimPrefix(strings.TrimSpace(expr), "${{")))
+	canEvaluateNow := err == nil
+	actionlint.VisitExprNode(exprNode, func(node, _ actionlint.ExprNode, entering bool) {
+		if variableNode, ok := node.(*actionlint.VariableNode); entering && ok {
+			switch strings.ToLower(variableNode.Name) {
+			case "env", "steps", "job":
+				canEvaluateNow = false
 			}
 		}
+	})
+	return canEvaluateNow
+}
 
-		env := &yaml.Node{}
-		if step.Environment != nil {
-			env = step.Environment.ToYamlNode()
-			if env.Kind != yaml.MappingNode {
-				return nil, fmt.Errorf("step.env: not a map")
-			}
-		}
+func isExpression(value string) bool {
+	return strings.Contains(value, "${{") && strings.Contains(value, "}}")
+}
 
-		continueOnError := "false"
-		if step.ContinueOnError != nil {
-			tmpcontinueOnError := step.ContinueOnError.ToRawObject()
-			if b, ok := tmpcontinueOnError.(bool); ok {
-				continueOnError = fmt.Sprint(b)
-			} else if s, ok := tmpcontinueOnError.(string); ok {
-				continueOnError = s
-			} else {
-				return nil, fmt.Errorf("ContinueOnError: Failed to translate")
+// escapeEvaluated prevents act from evaluating the result of an expression again
+func escapeEvaluated(value string) string {
+	if isExpression(value) {
+		return "${{ '" + strings.ReplaceAll(value, "'", "''") + "' }}"
+	}
+	return value
+}
+
+// isDynamicMapping returns true if the keys of the with or env mapping are only known after evaluating it,
+// this applies to mapping expressions, expression keys and insert directives
+func isDynamicMapping(node *yaml.Node) bool {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return isExpression(node.Value)
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if isExpression(node.Content[i].Value) {
+				return true
 			}
 		}
-		var timeoutMinutes string
-		if step.TimeoutInMinutes != nil {
-			rawTimeout := step.TimeoutInMinutes.ToRawObject()
-			if b, ok := rawTimeout.(float64); ok {
-				timeoutMinutes = fmt.Sprint(b)
-			} else if s, ok := rawTimeout.(string); ok {
-				timeoutMinutes = s
-			} else {
-				return nil, fmt.Errorf("TimeoutInMinutes: Failed to translate")
-			}
+	}
+	return false
+}
+
+// convertStepMapping converts the with or env mapping of a step, act evaluates expressions in the values when the step runs
+func convertStepMapping(node *yaml.Node, name string) (map[string]string, error) {
+	if node.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%v: not a map", name)
+	}
+	mapping := map[string]string{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		k, v := node.Content[i], node.Content[i+1]
+		if k.Kind != yaml.ScalarNode || v.Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("%v: %v is not a string", name, k.Value)
 		}
-		var displayName string = ""
-		if step.DisplayNameToken != nil {
-			rawDisplayName, ok := step.DisplayNameToken.ToRawObject().(string)
-			if !ok {
-				return nil, fmt.Errorf("DisplayNameToken: act doesn't support no strings")
-			}
-			displayName = rawDisplayName
+		if v.ShortTag() == "!!null" {
+			mapping[k.Value] = ""
+		} else {
+			mapping[k.Value] = v.Value
 		}
+	}
+	return mapping, nil
+}
+
+// evaluateStepMapping evaluates a dynamic with or env mapping, the values are escaped so act doesn't evaluate them again
+func evaluateStepMapping(ctx context.Context, eval runner.ExpressionEvaluator, token *protocol.TemplateToken, name string) (map[string]string, error) {
+	node := token.ToYamlNode()
+	if node == nil {
+		return nil, fmt.Errorf("%v: failed to convert", name)
+	}
+	if err := eval.EvaluateYamlNode(ctx, node); err != nil {
+		return nil, fmt.Errorf("%v: %w", name, err)
+	}
+	mapping, err := convertStepMapping(node, name)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range mapping {
+		mapping[k] = escapeEvaluated(v)
+	}
+	return mapping, nil
+}
+
+// toYamlMapping returns a mapping node of the string map
+func toYamlMapping(mapping map[string]string) yaml.Node {
+	node := yaml.Node{Kind: yaml.MappingNode}
+	for k, v := range mapping {
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: k}, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: v})
+	}
+	return node
+}
+
+// tokenToString converts a scalar template token to a string, expressions are kept as string
+func tokenToString(token *protocol.TemplateToken, name string) (string, error) {
+	if token == nil {
+		return "", nil
+	}
+	switch v := token.ToRawObject().(type) {
+	case string:
+		return v, nil
+	case bool, float64:
+		return fmt.Sprint(v), nil
+	}
+	return "", fmt.Errorf("%v: expected a string", name)
+}
+
+// convertTimeoutMinutes converts the evaluated timeout-minutes of a step to whole minutes, act doesn't support fractions
+func convertTimeoutMinutes(node *yaml.Node) (string, error) {
+	if node.Kind != yaml.ScalarNode {
+		return "", fmt.Errorf("timeout-minutes: expected a number")
+	}
+	if node.ShortTag() == "!!null" || strings.TrimSpace(node.Value) == "" {
+		return "", nil
+	}
+	minutes, err := strconv.ParseFloat(strings.TrimSpace(node.Value), 64)
+	if err != nil {
+		return "", fmt.Errorf("timeout-minutes: %v is not a number", node.Value)
+	}
+	if minutes <= 0 {
+		return "", nil
+	}
+	return strconv.FormatFloat(math.Ceil(minutes), 'f', 0, 64), nil
+}
+
+// applyInputs sets the inputs of the step, the inputs of scripts are the script and its options
+func applyInputs(step *model.Step, inputs map[string]string) error {
+	if step.Uses != "" {
+		step.With = inputs
+		return nil
+	}
+	script, ok := inputs["script"]
+	if !ok {
+		return fmt.Errorf("missing script")
+	}
+	step.Run = script
+	step.WorkingDirectory = inputs["workingDirectory"]
+	step.Shell = inputs["shell"]
+	return nil
+}
+
+// ConvertSteps converts the steps of the job request. Parts of a step, which act can't evaluate itself,
+// are evaluated by the returned hooks when the step starts
+func ConvertSteps(jobSteps []protocol.ActionStep) ([]*model.Step, *StepHooks, error) {
+	steps := []*model.Step{}
+	hooks := &StepHooks{hooks: map[string]*stepHook{}}
+	for _, step := range jobSteps {
+		st := strings.ToLower(step.Reference.Type)
 		if step.ContextName == "" {
 			step.ContextName = "___" + uuid.New().String()
 		}
-
+		mstep := &model.Step{
+			ID: step.ContextName,
+			If: yaml.Node{Kind: yaml.ScalarNode, Value: step.Condition},
+		}
 		switch st {
 		case "script":
-			rawwd, haswd := inputs["workingDirectory"]
-			var wd string
-			if haswd {
-				tmpwd, ok := rawwd.(string)
-				if !ok {
-					return nil, fmt.Errorf("workingDirectory: act doesn't support non strings")
-				}
-				wd = tmpwd
+		case "containerregistry":
+			mstep.Uses = "docker://" + step.Reference.Image
+		case "repository":
+			if strings.ToLower(step.Reference.RepositoryType) == "self" {
+				mstep.Uses = step.Reference.Path
 			} else {
-				wd = ""
-			}
-			rawshell, hasshell := inputs["shell"]
-			shell := ""
-			if hasshell {
-				sshell, ok := rawshell.(string)
-				if ok {
-					shell = sshell
-				} else {
-					return nil, fmt.Errorf("shell is not a string")
+				mstep.Uses = step.Reference.Name
+				if len(step.Reference.Path) > 0 {
+					mstep.Uses = mstep.Uses + "/" + step.Reference.Path
 				}
+				mstep.Uses = mstep.Uses + "@" + step.Reference.Ref
 			}
-			scriptContent, ok := inputs["script"].(string)
-			if ok {
-				steps = append(steps, &model.Step{
-					ID:                 step.ContextName,
-					If:                 yaml.Node{Kind: yaml.ScalarNode, Value: step.Condition},
-					Name:               displayName,
-					Run:                scriptContent,
-					WorkingDirectory:   wd,
-					Shell:              shell,
-					RawContinueOnError: continueOnError,
-					TimeoutMinutes:     timeoutMinutes,
-					Env:                *env,
-				})
-			} else {
-				return nil, fmt.Errorf("missing script")
+		default:
+			continue
+		}
+		hook := &stepHook{step: mstep}
+
+		if step.Inputs != nil {
+			node := step.Inputs.ToYamlNode()
+			if node == nil {
+				return nil, nil, fmt.Errorf("step.Inputs: failed to convert")
 			}
-		case "containerregistry", "repository":
-			uses := ""
-			if st == "containerregistry" {
-				uses = "docker://" + step.Reference.Image
-			} else if strings.ToLower(step.Reference.RepositoryType) == "self" {
-				uses = step.Reference.Path
+			if isDynamicMapping(node) {
+				hook.with = step.Inputs
 			} else {
-				uses = step.Reference.Name
-				if len(step.Reference.Path) > 0 {
-					uses = uses + "/" + step.Reference.Path
+				inputs, err := convertStepMapping(node, "step.Inputs")
+				if err != nil {
+					return nil, nil, err
 				}
-				uses = uses + "@" + step.Reference.Ref
-			}
-			with := map[string]string{}
-			for k, v := range inputs {
-				k, ok := k.(string)
-				if !ok {
-					return nil, fmt.Errorf("with input key is not a string")
+				if err := applyInputs(mstep, inputs); err != nil {
+					return nil, nil, err
 				}
-				val, ok := v.(string)
-				if !ok {
-					return nil, fmt.Errorf("with input value is not a string")
+			}
+		} else if mstep.Uses == "" {
+			return nil, nil, fmt.Errorf("missing script")
+		}
+
+		if step.Environment != nil {
+			node := step.Environment.ToYamlNode()
+			if node == nil {
+				return nil, nil, fmt.Errorf("step.env: failed to convert")
+			}
+			if isDynamicMapping(node) {
+				hook.env = step.Environment
+			} else {
+				env, err := convertStepMapping(node, "step.env")
+				if err != nil {
+					return nil, nil, err
 				}
-				with[k] = val
+				mstep.Env = toYamlMapping(env)
 			}
+		}
 
-			steps = append(steps, &model.Step{
-				ID:                 step.ContextName,
-				If:                 yaml.Node{Kind: yaml.ScalarNode, Value: step.Condition},
-				Name:               displayName,
-				Uses:               uses,
-				WorkingDirectory:   "",
-				With:               with,
-				RawContinueOnError: continueOnError,
-				TimeoutMinutes:     timeoutMinutes,
-				Env:                *env,
-			})
+		// act evaluates expressions of these fields when the step starts, invalid values fail the step
+		var err error
+		if mstep.RawContinueOnError, err = tokenToString(step.ContinueOnError, "continue-on-error"); err != nil {
+			hook.err = err
+		} else if mstep.RawContinueOnError == "" {
+			mstep.RawContinueOnError = "false"
+		}
+		if hook.displayName, err = tokenToString(step.DisplayNameToken, "name"); err != nil && hook.err == nil {
+			hook.err = err
+		}
+		mstep.Name = hook.displayName
+		if step.TimeoutInMinutes != nil {
+			if step.TimeoutInMinutes.Type == 3 {
+				hook.timeout = step.TimeoutInMinutes
+			} else if mstep.TimeoutMinutes, err = convertTimeoutMinutes(step.TimeoutInMinutes.ToYamlNode()); err != nil && hook.err == nil {
+				hook.err = err
+			}
+		}
+		if hook.with != nil || hook.env != nil || hook.timeout != nil || hook.err != nil {
+			hooks.add(hook)
 		}
+		steps = append(steps, mstep)
 	}
-	return steps, nil
+	return steps, hooks, nil
 }
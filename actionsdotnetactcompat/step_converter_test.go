@@ -0,0 +1,237 @@
+package actionsdotnetactcompat
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ChristopherHX/github-act-runner/protocol"
+	"github.com/nektos/act/pkg/model"
+	"github.com/nektos/act/pkg/runner"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func toTemplateToken(t *testing.T, content string) *protocol.TemplateToken {
+	if content == "" {
+		return nil
+	}
+	node := &yaml.Node{}
+	if err := yaml.Unmarshal([]byte(content), node); err != nil {
+		t.Fatal(err)
+	}
+	token, err := (&protocol.TemplateTokenConverter{AllowExpressions: true}).FromYamlNode(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func newStepTestRunContext() *runner.RunContext {
+	return &runner.RunContext{
+		Config: &runner.Config{},
+		Run: &model.Run{
+			JobID:    "test",
+			Workflow: &model.Workflow{Jobs: map[string]*model.Job{"test": {}}},
+		},
+		Env: map[string]string{"JOB_ENV": "job"},
+		StepResults: map[string]*model.StepResult{
+			"prev": {Outputs: map[string]string{"json": `{"a":"1","b":"${{ b }}"}`, "minutes": "1.5"}},
+		},
+		ContextData: map[string]interface{}{},
+	}
+}
+
+// startStep evaluates the step like act does when the step starts
+func startStep(ctx context.Context, rc *runner.RunContext, step *model.Step) (string, bool, error) {
+	name := rc.NewExpressionEvaluator(ctx).Interpolate(ctx, step.String())
+	enabled, err := runner.EvalBool(ctx, rc.NewExpressionEvaluator(ctx), step.If.Value, 0)
+	return name, enabled, err
+}
+
+func TestConvertSteps(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		ref      string
+		inputs   string
+		env      string
+		timeout  string
+		display  string
+		err      string
+		stepErr  string
+		stepName string
+		with     map[string]string
+		run      string
+		stepEnv  map[string]string
+		minutes  string
+	}{
+		{
+			name:     "number and bool values",
+			ref:      "actions/checkout",
+			inputs:   "fetch-depth: 0\nlfs: true\nref: ~",
+			env:      "A: 1.5\nB: false",
+			stepName: "actions/checkout@v4",
+			with:     map[string]string{"fetch-depth": "0", "lfs": "true", "ref": ""},
+			stepEnv:  map[string]string{"A": "1.5", "B": "false"},
+		},
+		{
+			name:     "expression values are evaluated by act",
+			ref:      "actions/checkout",
+			inputs:   "ref: ${{ steps.prev.outputs.minutes }}",
+			env:      "A: ${{ env.JOB_ENV }}",
+			stepName: "actions/checkout@v4",
+			with:     map[string]string{"ref": "${{steps.prev.outputs.minutes}}"},
+			stepEnv:  map[string]string{"A": "${{env.JOB_ENV}}"},
+		},
+		{
+			name:     "mapping expression using steps",
+			ref:      "actions/checkout",
+			inputs:   "${{ fromJSON(steps.prev.outputs.json) }}",
+			display:  "Checkout ${{ env.JOB_ENV }}",
+			stepName: "Checkout job",
+			with:     map[string]string{"a": "1", "b": "${{ '${{ b }}' }}"},
+		},
+		{
+			name:     "expression keys using env",
+			ref:      "actions/checkout",
+			inputs:   "${{ env.STEP_KEY }}: ${{ env.JOB_ENV }}",
+			env:      "STEP_KEY: key",
+			stepName: "actions/checkout@v4",
+			with:     map[string]string{"key": "job"},
+			stepEnv:  map[string]string{"STEP_KEY": "key"},
+		},
+		{
+			name:     "insert directive",
+			ref:      "actions/checkout",
+			env:      "${{ insert }}: ${{ fromJSON(steps.prev.outputs.json) }}\nC: c",
+			stepName: "actions/checkout@v4",
+			stepEnv:  map[string]string{"a": "1", "b": "${{ '${{ b }}' }}", "C": "c"},
+		},
+		{
+			name:     "script with mapping expression",
+			inputs:   "${{ fromJSON('{\"script\":\"echo ${{ a }}\"}') }}",
+			stepName: "echo ${{ a }}",
+			run:      "${{ 'echo ${{ a }}' }}",
+		},
+		{
+			name:     "timeout expression",
+			ref:      "actions/checkout",
+			timeout:  "${{ steps.prev.outputs.minutes }}",
+			stepName: "actions/checkout@v4",
+			minutes:  "2",
+		},
+		{
+			name:     "timeout number",
+			ref:      "actions/checkout",
+			timeout:  "2.5",
+			stepName: "actions/checkout@v4",
+			minutes:  "3",
+		},
+		{
+			name:     "invalid timeout expression fails the step",
+			ref:      "actions/checkout",
+			timeout:  "${{ env.JOB_ENV }}",
+			stepName: "actions/checkout@v4",
+			stepErr:  "timeout-minutes: job is not a number",
+		},
+		{
+			name:     "invalid timeout fails the step",
+			ref:      "actions/checkout",
+			timeout:  "ten",
+			stepName: "actions/checkout@v4",
+			stepErr:  "timeout-minutes: ten is not a number",
+		},
+		{
+			name:     "non-scalar display name fails the step",
+			ref:      "actions/checkout",
+			display:  "[a]",
+			stepName: "actions/checkout@v4",
+			stepErr:  "name: expected a string",
+		},
+		{
+			name:     "mapping expression which isn't a map fails the step",
+			ref:      "actions/checkout",
+			inputs:   "${{ env.JOB_ENV }}",
+			stepName: "actions/checkout@v4",
+			stepErr:  "step.Inputs: not a map",
+		},
+		{
+			name:     "nested values fail the step",
+			ref:      "actions/checkout",
+			env:      "${{ insert }}: ${{ fromJSON('{\"a\":[1]}') }}",
+			stepName: "actions/checkout@v4",
+			stepErr:  "step.env: a is not a string",
+		},
+		{
+			name:   "nested static value",
+			ref:    "actions/checkout",
+			inputs: "a: [1]",
+			err:    "step.Inputs: a is not a string",
+		},
+		{
+			name:   "missing script",
+			inputs: "shell: bash",
+			err:    "missing script",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			step := protocol.ActionStep{
+				ContextName:      "step",
+				Condition:        "success()",
+				Inputs:           toTemplateToken(t, tc.inputs),
+				Environment:      toTemplateToken(t, tc.env),
+				TimeoutInMinutes: toTemplateToken(t, tc.timeout),
+				DisplayNameToken: toTemplateToken(t, tc.display),
+				Reference:        protocol.ActionStepDefinitionReference{Type: "script"},
+			}
+			if tc.ref != "" {
+				step.Reference = protocol.ActionStepDefinitionReference{Type: "repository", Name: tc.ref, Ref: "v4"}
+			}
+			steps, hooks, err := ConvertSteps([]protocol.ActionStep{step})
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Len(t, steps, 1)
+
+			ctx := context.Background()
+			rc := newStepTestRunContext()
+			hooks.Attach(ctx, rc)
+			name, enabled, err := startStep(ctx, rc, steps[0])
+			assert.Equal(t, tc.stepName, name)
+			if tc.stepErr != "" {
+				assert.ErrorContains(t, err, tc.stepErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.True(t, enabled)
+			assert.Equal(t, tc.with, steps[0].With)
+			assert.Equal(t, tc.run, steps[0].Run)
+			assert.Equal(t, tc.minutes, steps[0].TimeoutMinutes)
+			env := steps[0].Environment()
+			if tc.stepEnv == nil {
+				assert.Empty(t, env)
+			} else {
+				assert.Equal(t, tc.stepEnv, env)
+			}
+		})
+	}
+}
+
+func TestConvertStepsKeepsCondition(t *testing.T) {
+	steps, hooks, err := ConvertSteps([]protocol.ActionStep{{
+		ContextName: "step",
+		Condition:   "${{ env.JOB_ENV == 'other' }}",
+		Inputs:      toTemplateToken(t, "${{ insert }}: ${{ fromJSON('{\"script\":\"echo\"}') }}"),
+		Reference:   protocol.ActionStepDefinitionReference{Type: "script"},
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, "exit 1", steps[0].Run, "the placeholder of the script must not run")
+	ctx := context.Background()
+	rc := newStepTestRunContext()
+	hooks.Attach(ctx, rc)
+	_, enabled, err := startStep(ctx, rc, steps[0])
+	assert.NoError(t, err)
+	assert.False(t, enabled)
+	assert.Equal(t, "step", hooks.DisplayName(steps[0]))
+}
@@ -0,0 +1,163 @@
+package actionsdotnetactcompat
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ChristopherHX/github-act-runner/protocol"
+	"github.com/nektos/act/pkg/model"
+	"github.com/nektos/act/pkg/runner"
+)
+
+// stepHooksContext is the name of the context, which exposes the step hooks to the expressions of act
+const stepHooksContext = "__github_act_runner_steps"
+
+// stepHook evaluates the parts of a step, which act can't evaluate itself, when the step starts
+type stepHook struct {
+	hooks       *StepHooks
+	step        *model.Step
+	with        *protocol.TemplateToken
+	env         *protocol.TemplateToken
+	timeout     *protocol.TemplateToken
+	displayName string
+	// err is an invalid value of the job request, which fails the step
+	err     error
+	evalErr error
+	name    string
+}
+
+// StepHooks are the hooks of the steps returned by ConvertSteps
+type StepHooks struct {
+	ctx   context.Context
+	rc    *runner.RunContext
+	hooks map[string]*stepHook
+}
+
+// stepHookContext is the value of a step in the stepHooksContext
+type stepHookContext struct {
+	Name  stepHookName  `json:"name"`
+	Ready stepHookReady `json:"ready"`
+}
+
+// stepHookName evaluates the step and returns its display name, act interpolates the name of a step before it sets up the env of the step
+type stepHookName struct {
+	hook *stepHook
+}
+
+func (n stepHookName) MarshalText() ([]byte, error) {
+	h := n.hook
+	rc := h.hooks.rc
+	// act only adds the result of the step after it has been started, later interpolations of the name use the evaluated step
+	if _, started := rc.StepResults[h.step.ID]; !started {
+		h.name, h.evalErr = h.evaluate(h.hooks.ctx, rc)
+	}
+	return []byte(h.name), nil
+}
+
+// stepHookReady fails the step if it couldn't be evaluated, act evaluates the condition of a step after it has set up the env of the step
+type stepHookReady struct {
+	hook *stepHook
+}
+
+func (r stepHookReady) MarshalText() ([]byte, error) {
+	if r.hook.err != nil {
+		return nil, r.hook.err
+	}
+	if r.hook.evalErr != nil {
+		return nil, r.hook.evalErr
+	}
+	return []byte("true"), nil
+}
+
+func (hooks *StepHooks) add(h *stepHook) {
+	h.hooks = hooks
+	hooks.hooks[h.step.ID] = h
+	ref := fmt.Sprintf("%s['%s']", stepHooksContext, strings.ReplaceAll(h.step.ID, "'", "''"))
+	h.step.Name = "${{ " + ref + ".name }}"
+	if h.with != nil && h.step.Uses == "" {
+		// act decides the type of the step before the hook has set the script, the placeholder never runs,
+		// because the hook either replaces it or fails the step
+		h.step.Run = "exit 1"
+	}
+	condition := strings.TrimSpace(h.step.If.Value)
+	if strings.HasPrefix(condition, "${{") && strings.HasSuffix(condition, "}}") {
+		condition = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(condition, "${{"), "}}"))
+	}
+	if condition == "" {
+		condition = "success()"
+	}
+	h.step.If.Value = fmt.Sprintf("(%s) && %s.ready", condition, ref)
+}
+
+// evaluate applies the evaluated inputs, env and timeout to the step and returns its display name
+func (h *stepHook) evaluate(ctx context.Context, rc *runner.RunContext) (string, error) {
+	step := *h.step
+	step.Name = h.displayName
+	if h.env != nil {
+		env, err := evaluateStepMapping(ctx, rc.NewExpressionEvaluator(ctx), h.env, "step.env")
+		if err != nil {
+			return step.String(), err
+		}
+		h.step.Env = toYamlMapping(env)
+	}
+	env := map[string]string{}
+	for k, v := range rc.GetEnv() {
+		env[k] = v
+	}
+	ee := rc.NewExpressionEvaluator(ctx)
+	for k, v := range h.step.Environment() {
+		env[k] = ee.Interpolate(ctx, v)
+	}
+	eval := rc.NewExpressionEvaluatorWithEnv(ctx, env)
+	if h.with != nil {
+		inputs, err := evaluateStepMapping(ctx, eval, h.with, "step.Inputs")
+		if err != nil {
+			return step.String(), err
+		}
+		if err := applyInputs(h.step, inputs); err != nil {
+			return step.String(), err
+		}
+	}
+	if h.timeout != nil {
+		node := h.timeout.ToYamlNode()
+		if err := eval.EvaluateYamlNode(ctx, node); err != nil {
+			return step.String(), fmt.Errorf("timeout-minutes: %w", err)
+		}
+		timeout, err := convertTimeoutMinutes(node)
+		if err != nil {
+			return step.String(), err
+		}
+		h.step.TimeoutMinutes = timeout
+	}
+	step = *h.step
+	step.Name = h.displayName
+	return eval.Interpolate(ctx, step.String()), nil
+}
+
+// DisplayName returns the name of the step before it has been evaluated
+func (hooks *StepHooks) DisplayName(step *model.Step) string {
+	if h, ok := hooks.hooks[step.ID]; ok {
+		if h.displayName != "" || h.with == nil {
+			s := *step
+			s.Name = h.displayName
+			return s.String()
+		}
+		return step.ID
+	}
+	return step.String()
+}
+
+// Attach adds the hooks to the context data of the job
+func (hooks *StepHooks) Attach(ctx context.Context, rc *runner.RunContext) {
+	hooks.ctx = ctx
+	hooks.rc = rc
+	steps := map[string]stepHookContext{}
+	for id, h := range hooks.hooks {
+		steps[id] = stepHookContext{Name: stepHookName{h}, Ready: stepHookReady{h}}
+	}
+	if rc.ContextData == nil {
+		rc.ContextData = map[string]interface{}{}
+	}
+	rc.ContextData[stepHooksContext] = steps
+}
@@ -0,0 +1,106 @@
+package actionsdotnetactcompat
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/ChristopherHX/github-act-runner/protocol"
+	"github.com/ChristopherHX/github-act-runner/protocol/logger"
+	"github.com/nektos/act/pkg/model"
+)
+
+// maxStepSummarySize is the size limit of actions/runner for the summary of a single step
+const maxStepSummarySize = 1024 * 1024
+
+// stepSummaries uploads the GITHUB_STEP_SUMMARY files of the steps
+type stepSummaries struct {
+	jlogger *logger.JobLogger
+	mu      sync.Mutex
+	wg      sync.WaitGroup
+	errs    []error
+	inner   map[string]*bytes.Buffer
+}
+
+func newStepSummaries(jlogger *logger.JobLogger) *stepSummaries {
+	return &stepSummaries{
+		jlogger: jlogger,
+		inner:   map[string]*bytes.Buffer{},
+	}
+}
+
+// add records the summary of a finished step, summaries of steps inside of composite actions are uploaded together with the step using the action.
+// It returns a warning if the summary has been dropped
+func (s *stepSummaries) add(rec *protocol.TimelineRecord, nested bool, summary []byte) string {
+	if nested {
+		if len(summary) > 0 {
+			buf, ok := s.inner[rec.ID]
+			if !ok {
+				buf = &bytes.Buffer{}
+				s.inner[rec.ID] = buf
+			}
+			buf.Write(summary)
+		}
+		return ""
+	}
+	if buf, ok := s.inner[rec.ID]; ok {
+		// act truncates the file for every step of the composite action, the file only repeats the last summary
+		delete(s.inner, rec.ID)
+		summary = buf.Bytes()
+	}
+	if len(summary) == 0 {
+		return ""
+	}
+	if len(summary) > maxStepSummarySize {
+		return fmt.Sprintf("$GITHUB_STEP_SUMMARY upload aborted, supports content up to a size of %vk, got %vk", maxStepSummarySize/1024, len(summary)/1024)
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+		if err := s.jlogger.UploadStepSummary(ctx, rec, summary); err != nil {
+			s.mu.Lock()
+			s.errs = append(s.errs, fmt.Errorf("failed to upload the step summary of %v: %w", rec.Name, err))
+			s.mu.Unlock()
+		}
+	}()
+	return ""
+}
+
+// Wait waits for all uploads and returns their errors
+func (s *stepSummaries) Wait() []error {
+	s.wg.Wait()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.errs
+}
+
+// collectStepSummary reads the summary of a finished step from the job container and queues its upload.
+// It returns warnings to add to the log, the logger can't be used while formatting
+func (f *ghaFormatter) collectStepSummary(stepResult interface{}, stepIDArray []string) []string {
+	if f.summaries == nil || f.rc == nil || f.rc.JobContainer == nil || stepResult == model.StepStatusSkipped {
+		// Skipped steps don't reset the file of the previous step
+		return nil
+	}
+	cur := f.logger.Current()
+	if cur == nil {
+		return nil
+	}
+	nested := len(stepIDArray) > 1
+	var summary []byte
+	if _, hasInner := f.summaries.inner[cur.ID]; nested || !hasInner {
+		content, err := readContainerFile(f.ctx, f.rc.JobContainer, path.Join(f.rc.JobContainer.GetActPath(), "workflow", "SUMMARY.md"))
+		if err != nil {
+			return []string{fmt.Sprintf("Failed to read $GITHUB_STEP_SUMMARY: %v", err)}
+		}
+		summary = []byte(f.mask(string(content)))
+	}
+	if warning := f.summaries.add(cur, nested, summary); warning != "" {
+		return []string{warning}
+	}
+	return nil
+}
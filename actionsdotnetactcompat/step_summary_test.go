@@ -0,0 +1,143 @@
+package actionsdotnetactcompat
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ChristopherHX/github-act-runner/protocol"
+	"github.com/ChristopherHX/github-act-runner/protocol/fakeservice"
+	"github.com/ChristopherHX/github-act-runner/protocol/logger"
+	"github.com/nektos/act/pkg/container"
+	"github.com/nektos/act/pkg/model"
+	"github.com/nektos/act/pkg/runner"
+	"github.com/stretchr/testify/assert"
+)
+
+// newSummaryTestLogger returns a job logger, which uploads the step summaries to the results service or as attachments
+func newSummaryTestLogger(t *testing.T, server *fakeservice.Server, useResults bool) (*protocol.AgentJobRequestMessage, *logger.JobLogger) {
+	jobreq := server.NewJob("build")
+	con, _, err := jobreq.GetConnection("SystemVssConnection")
+	if err != nil {
+		t.Fatal(err)
+	}
+	jlogger := &logger.JobLogger{
+		JobRequest:      jobreq,
+		Connection:      con,
+		TimelineRecords: &protocol.TimelineRecordWrapper{},
+	}
+	if useResults {
+		resultsCon := *con
+		resultsCon.TenantURL = server.URL + fakeservice.ResultsPath
+		jlogger.ResultsConnection = &resultsCon
+	}
+	job := jlogger.Append(protocol.CreateTimelineEntry("", jobreq.JobName, jobreq.JobDisplayName))
+	job.ID = jobreq.JobID
+	jlogger.MoveNext()
+	return jobreq, jlogger
+}
+
+func TestStepSummariesAdd(t *testing.T) {
+	server := fakeservice.NewServer()
+	defer server.Close()
+	for _, tc := range []struct {
+		name       string
+		useResults bool
+		summary    func(rec *protocol.TimelineRecord) string
+	}{
+		{
+			name:       "results",
+			useResults: true,
+			summary:    func(rec *protocol.TimelineRecord) string { return server.StepSummary(rec.ID) },
+		},
+		{
+			name: "attachment",
+			summary: func(rec *protocol.TimelineRecord) string {
+				return server.Attachment(rec.ID, protocol.StepSummaryAttachmentType, rec.ID)
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			jobreq, jlogger := newSummaryTestLogger(t, server, tc.useResults)
+			summaries := newStepSummaries(jlogger)
+
+			composite := jlogger.Append(protocol.CreateTimelineEntry(jobreq.JobID, "composite", "Composite"))
+			assert.Empty(t, summaries.add(composite, true, []byte("# first\n")))
+			assert.Empty(t, summaries.add(composite, true, nil))
+			assert.Empty(t, summaries.add(composite, true, []byte("# second\n")))
+			// act only keeps the summary of the last step of the composite action in the file
+			assert.Empty(t, summaries.add(composite, false, []byte("# second\n")))
+
+			step := jlogger.Append(protocol.CreateTimelineEntry(jobreq.JobID, "step", "Step"))
+			assert.Empty(t, summaries.add(step, false, []byte("# step\n")))
+
+			empty := jlogger.Append(protocol.CreateTimelineEntry(jobreq.JobID, "empty", "Empty"))
+			assert.Empty(t, summaries.add(empty, false, nil))
+
+			limit := jlogger.Append(protocol.CreateTimelineEntry(jobreq.JobID, "limit", "Limit"))
+			assert.Empty(t, summaries.add(limit, false, []byte(strings.Repeat("a", maxStepSummarySize))))
+
+			large := jlogger.Append(protocol.CreateTimelineEntry(jobreq.JobID, "large", "Large"))
+			assert.Equal(t, "$GITHUB_STEP_SUMMARY upload aborted, supports content up to a size of 1024k, got 1025k",
+				summaries.add(large, false, []byte(strings.Repeat("a", maxStepSummarySize+1024))))
+
+			assert.Empty(t, summaries.Wait())
+			assert.Equal(t, "# first\n# second\n", tc.summary(composite))
+			assert.Empty(t, summaries.inner, "the summaries of the composite action have been uploaded")
+			assert.Equal(t, "# step\n", tc.summary(step))
+			assert.Empty(t, tc.summary(empty))
+			assert.Len(t, tc.summary(limit), maxStepSummarySize)
+			assert.Empty(t, tc.summary(large))
+		})
+	}
+}
+
+func TestCollectStepSummary(t *testing.T) {
+	server := fakeservice.NewServer()
+	defer server.Close()
+	jobreq, jlogger := newSummaryTestLogger(t, server, true)
+	jobreq.Variables["token"] = protocol.VariableValue{Value: "hunter2", IsSecret: true}
+	jobreq.MaskHints = []protocol.MaskHint{{Type: "regex", Value: "ghs_[a-z]+"}}
+	actPath := t.TempDir()
+	summaryFile := filepath.Join(actPath, "workflow", "SUMMARY.md")
+	if err := os.MkdirAll(filepath.Dir(summaryFile), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	summaries := newStepSummaries(jlogger)
+	f := &ghaFormatter{
+		rqt:       jobreq,
+		rc:        &runner.RunContext{JobContainer: &container.HostEnvironment{ActPath: actPath}},
+		logger:    jlogger,
+		ctx:       context.Background(),
+		summaries: summaries,
+	}
+	step := jlogger.Append(protocol.CreateTimelineEntry(jobreq.JobID, "step", "Step"))
+	skipped := jlogger.Append(protocol.CreateTimelineEntry(jobreq.JobID, "skipped", "Skipped"))
+	failed := jlogger.Append(protocol.CreateTimelineEntry(jobreq.JobID, "failed", "Failed"))
+
+	assert.Equal(t, step, jlogger.Current())
+	if err := os.WriteFile(summaryFile, []byte("token hunter2 and ghs_abc\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, f.collectStepSummary(model.StepStatusSuccess, []string{"step"}))
+
+	jlogger.MoveNext()
+	// skipped steps don't truncate the file of the previous step
+	assert.Empty(t, f.collectStepSummary(model.StepStatusSkipped, []string{"skipped"}))
+
+	assert.Empty(t, summaries.Wait())
+	assert.Equal(t, "token *** and ***\n", server.StepSummary(step.ID))
+	assert.Empty(t, server.StepSummary(skipped.ID))
+
+	jlogger.MoveNext()
+	assert.Equal(t, failed, jlogger.Current())
+	if err := os.Remove(summaryFile); err != nil {
+		t.Fatal(err)
+	}
+	warnings := f.collectStepSummary(model.StepStatusFailure, []string{"failed"})
+	if assert.Len(t, warnings, 1) {
+		assert.Contains(t, warnings[0], "Failed to read $GITHUB_STEP_SUMMARY")
+	}
+}
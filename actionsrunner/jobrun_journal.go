@@ -0,0 +1,162 @@
+package actionsrunner
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/ChristopherHX/github-act-runner/common"
+	"github.com/ChristopherHX/github-act-runner/protocol"
+	runservice "github.com/ChristopherHX/github-act-runner/protocol/run"
+	"github.com/ChristopherHX/github-act-runner/runnerconfiguration"
+	"github.com/sirupsen/logrus"
+)
+
+const jobrunJournalFile = "jobrun.json"
+
+// jobrunJournal keeps track of all jobs in flight, to finish them as failed if the runner crashed while executing them
+type jobrunJournal struct {
+	mu        sync.Mutex
+	runnerenv RunnerEnvironment
+	jobs      []*JobRun
+}
+
+func loadJobrunJournal(runnerenv RunnerEnvironment, logger logrus.FieldLogger) *jobrunJournal {
+	journal := &jobrunJournal{runnerenv: runnerenv}
+	if err := runnerenv.ReadJson(jobrunJournalFile, &journal.jobs); err != nil {
+		// Backward compatibility, older runners only tracked a single job
+		jobrun := &JobRun{}
+		if err2 := runnerenv.ReadJson(jobrunJournalFile, jobrun); err2 == nil {
+			journal.jobs = []*JobRun{jobrun}
+		} else {
+			logger.Debugf("%v is corrupted or does not exist: %v", jobrunJournalFile, err.Error())
+		}
+	}
+	return journal
+}
+
+func (jobrun *JobRun) matches(instance *runnerconfiguration.RunnerInstance) bool {
+	return jobrun.RegistrationURL == instance.RegistrationURL && jobrun.Name == instance.Agent.Name
+}
+
+func (jobrun *JobRun) same(other *JobRun) bool {
+	return jobrun.RegistrationURL == other.RegistrationURL && jobrun.Name == other.Name && jobrun.RequestID == other.RequestID && jobrun.JobID == other.JobID
+}
+
+// save requires the lock to be held
+func (journal *jobrunJournal) save() error {
+	if len(journal.jobs) == 0 {
+		if err := journal.runnerenv.Remove(jobrunJournalFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	return journal.runnerenv.WriteJson(jobrunJournalFile, journal.jobs)
+}
+
+// Add records a job before it starts
+func (journal *jobrunJournal) Add(jobrun *JobRun) error {
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+	journal.jobs = append(journal.jobs, jobrun)
+	return journal.save()
+}
+
+// Remove drops a job after it has been finished
+func (journal *jobrunJournal) Remove(jobrun *JobRun) error {
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+	for i, j := range journal.jobs {
+		if j.same(jobrun) {
+			journal.jobs = append(journal.jobs[:i], journal.jobs[i+1:]...)
+			break
+		}
+	}
+	return journal.save()
+}
+
+// owner returns the runner instance, which finishes the job of a previous run of the runner.
+// Jobs of a removed runner instance are finished by the first instance with the same registration url,
+// if the runner only has a single instance it takes over all jobs to stay compatible to older runners
+func (jobrun *JobRun) owner(instances []*runnerconfiguration.RunnerInstance) *runnerconfiguration.RunnerInstance {
+	if len(instances) == 1 {
+		return instances[0]
+	}
+	for _, instance := range instances {
+		if jobrun.matches(instance) {
+			return instance
+		}
+	}
+	for _, instance := range instances {
+		if jobrun.RegistrationURL == instance.RegistrationURL {
+			return instance
+		}
+	}
+	return nil
+}
+
+// Pending returns the jobs recorded by a previous run of the runner, which the runner instance finishes
+func (journal *jobrunJournal) Pending(instance *runnerconfiguration.RunnerInstance, instances []*runnerconfiguration.RunnerInstance) []*JobRun {
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+	var pending []*JobRun
+	for _, j := range journal.jobs {
+		if j.owner(instances) == instance {
+			pending = append(pending, j)
+		}
+	}
+	return pending
+}
+
+// Unreconcilable returns the jobs, which no runner instance can finish.
+// They stay in the journal until a runner instance with the same registration url is configured again
+func (journal *jobrunJournal) Unreconcilable(instances []*runnerconfiguration.RunnerInstance) []*JobRun {
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+	var unreconcilable []*JobRun
+	for _, j := range journal.jobs {
+		if j.owner(instances) == nil {
+			unreconcilable = append(unreconcilable, j)
+		}
+	}
+	return unreconcilable
+}
+
+// finishStuckJob finishes a job of a previous run of the runner with Status Failed
+func finishStuckJob(vssConnection *protocol.VssConnection, jobrun *JobRun) {
+	logger := vssConnection.GetLogger().WithFields(logrus.Fields{
+		common.LogFieldJobID:     jobrun.JobID,
+		common.LogFieldRequestID: jobrun.RequestID,
+	})
+	con := *vssConnection
+	con.RetryPolicy = protocol.PersistentRetryPolicy
+	finish := func() error {
+		if jobrun.RunServiceURL != "" {
+			con.TenantURL = jobrun.RunServiceURL
+			completejobUrl, _ := url.Parse(jobrun.RunServiceURL)
+			completejobUrl.Path = path.Join(completejobUrl.Path, "completejob")
+			payload := &runservice.CompleteJobRequest{
+				JobID:      jobrun.JobID,
+				Conclusion: "Failed",
+			}
+			if jobrun.Plan != nil {
+				payload.PlanID = jobrun.Plan.PlanID
+			}
+			return con.RequestWithContext2(context.Background(), "POST", completejobUrl.String(), "", payload, nil)
+		}
+		return con.FinishJob(&protocol.JobEvent{
+			Name:      "JobCompleted",
+			JobID:     jobrun.JobID,
+			RequestID: jobrun.RequestID,
+			Result:    "Failed",
+		}, jobrun.Plan)
+	}
+	if err := finish(); err != nil {
+		logger.Errorf("Failed to finish previous stuck job %v with Status Failed: %v", jobrun.JobID, err.Error())
+	} else {
+		logger.Infof("Finished previous stuck job %v with Status Failed", jobrun.JobID)
+	}
+}
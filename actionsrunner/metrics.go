@@ -0,0 +1,16 @@
+package actionsrunner
+
+import (
+	"github.com/ChristopherHX/github-act-runner/metrics"
+)
+
+var (
+	sessionsCreated = metrics.NewCounterVec("github_act_runner_sessions_created_total", "Number of created message sessions", "runner", "url")
+	sessionsExpired = metrics.NewCounterVec("github_act_runner_sessions_expired_total", "Number of message sessions reported as expired by the actions service", "runner", "url")
+	pollErrors      = metrics.NewCounterVec("github_act_runner_message_poll_errors_total", "Number of message polls without a message by kind, EOF means the long poll timed out", "runner", "url", "kind")
+	jobsStarted     = metrics.NewCounterVec("github_act_runner_jobs_started_total", "Number of received jobs", "runner", "url")
+	jobsFinished    = metrics.NewCounterVec("github_act_runner_jobs_finished_total", "Number of finished jobs by result", "runner", "url", "result")
+	jobsRunning     = metrics.NewGaugeVec("github_act_runner_jobs_running", "Number of jobs currently executed by this process")
+	jobDuration     = metrics.NewHistogramVec("github_act_runner_job_duration_seconds", "Time between receiving and finishing a job", metrics.DefaultBuckets, "runner", "url", "result")
+	renewFailures   = metrics.NewCounterVec("github_act_runner_renewjob_failures_total", "Number of failed job renewals", "runner", "url")
+)
@@ -2,12 +2,10 @@ package actionsrunner
 
 import (
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"net/http"
 	"net/url"
 	"path"
 	"runtime"
@@ -24,12 +22,40 @@ import (
 )
 
 type RunRunner struct {
-	Once     bool
-	Trace    bool
-	Version  string
+	Once    bool
+	Trace   bool
+	Version string
+	// MaxParallel limits the number of jobs executed at the same time by all instances, defaults to 1
+	MaxParallel int
+	// Status is updated with the state of all message loops, if set
+	Status *Status
+	// Logger receives all runner-level output, defaults to a text logger writing to the RunnerEnvironment
+	Logger   logrus.FieldLogger
 	Settings *runnerconfiguration.RunnerSettings
 }
 
+type printfWriter struct {
+	BasicLogger
+}
+
+func (w *printfWriter) Write(p []byte) (int, error) {
+	w.Printf("%s", p)
+	return len(p), nil
+}
+
+func (run *RunRunner) logger(runnerenv RunnerEnvironment) logrus.FieldLogger {
+	if run.Logger != nil {
+		return run.Logger
+	}
+	logger := logrus.New()
+	logger.SetOutput(&printfWriter{runnerenv})
+	logger.SetFormatter(&common.TextFormatter{})
+	if run.Trace {
+		logger.SetLevel(logrus.DebugLevel)
+	}
+	return logger
+}
+
 type JobRun struct {
 	RequestID       int64
 	JobID           string
@@ -56,8 +82,13 @@ func (run *RunRunner) Run(runnerenv RunnerEnvironment, listenerctx context.Conte
 	}
 	ctx, cancel := context.WithCancel(corectx)
 	defer cancel()
-	// This is used to wait for possible multiple jobs, they would execute sequentially and we need to wait for all
-	var joblock sync.Mutex
+	logger := run.logger(runnerenv)
+	status := run.Status
+	if status == nil {
+		status = &Status{}
+	}
+	// This is used to wait for possible multiple jobs, at most MaxParallel of them execute at the same time and we need to wait for all
+	scheduler := newJobScheduler(run.MaxParallel)
 	var jobCompletedWG sync.WaitGroup
 	allJobsDone := func() chan struct{} {
 		ch := make(chan struct{})
@@ -71,6 +102,9 @@ func (run *RunRunner) Run(runnerenv RunnerEnvironment, listenerctx context.Conte
 		<-allJobsDone()
 	}()
 	firstJobReceived := false
+	// Stops all instances from accepting new jobs, while running jobs continue
+	listeningctx, stopListening := context.WithCancel(ctx)
+	defer stopListening()
 	go func() {
 		select {
 		case <-ctx.Done():
@@ -81,6 +115,7 @@ func (run *RunRunner) Run(runnerenv RunnerEnvironment, listenerctx context.Conte
 			case <-time.After(100 * time.Millisecond):
 				run.Once = true
 				firstJobReceived = true
+				stopListening()
 			}
 		}
 	}()
@@ -93,31 +128,36 @@ func (run *RunRunner) Run(runnerenv RunnerEnvironment, listenerctx context.Conte
 	defer func() {
 		if firstJobReceived && isEphemeral {
 			if err := runnerenv.Remove("settings.json"); err != nil {
-				runnerenv.Printf("Warning: Cannot delete settings.json after ephemeral exit: %v\n", err.Error())
+				logger.Warnf("Cannot delete settings.json after ephemeral exit: %v", err.Error())
 			}
 			if err := runnerenv.Remove("sessions.json"); err != nil {
-				runnerenv.Printf("Warning: Cannot delete sessions.json after ephemeral exit: %v\n", err.Error())
+				logger.Warnf("Cannot delete sessions.json after ephemeral exit: %v", err.Error())
 			}
 		}
 	}()
+	journal := loadJobrunJournal(runnerenv, logger)
+	for _, jobrun := range journal.Unreconcilable(settings.Instances) {
+		logger.WithFields(logrus.Fields{
+			common.LogFieldJobID:     jobrun.JobID,
+			common.LogFieldRequestID: jobrun.RequestID,
+		}).Warnf("Cannot finish previous stuck job %v, no runner of %v is configured. Keeping it in %v until a runner of %v is configured again", jobrun.JobID, jobrun.RegistrationURL, jobrunJournalFile, jobrun.RegistrationURL)
+	}
 	var sessions []*protocol.TaskAgentSession
-	if err := runnerenv.ReadJson("sessions.json", &sessions); err != nil && run.Trace {
-		runnerenv.Printf("sessions.json is corrupted or does not exist: %v\n", err.Error())
+	if err := runnerenv.ReadJson("sessions.json", &sessions); err != nil {
+		logger.Debugf("sessions.json is corrupted or does not exist: %v", err.Error())
 	}
 	{
 		// Backward compatibility
 		var session protocol.TaskAgentSession
 		if err := runnerenv.ReadJson("session.json", &session); err != nil {
-			if run.Trace {
-				runnerenv.Printf("session.json is corrupted or does not exist: %v\n", err.Error())
-			}
+			logger.Debugf("session.json is corrupted or does not exist: %v", err.Error())
 		} else {
 			sessions = append(sessions, &session)
 			// Save new format
 			runnerenv.WriteJson("sessions.json", sessions)
 			// Cleanup old files
 			if err := runnerenv.Remove("session.json"); err != nil {
-				runnerenv.Printf("Warning: Cannot delete session.json: %v\n", err.Error())
+				logger.Warnf("Cannot delete session.json: %v", err.Error())
 			}
 		}
 	}
@@ -126,66 +166,62 @@ func (run *RunRunner) Run(runnerenv RunnerEnvironment, listenerctx context.Conte
 
 	for {
 		mu := &sync.Mutex{}
-		joblisteningctx, cancelJobListening := context.WithCancel(ctx)
+		joblisteningctx, cancelJobListening := context.WithCancel(listeningctx)
 		defer cancelJobListening()
 		wg := new(sync.WaitGroup)
 		wg.Add(len(settings.Instances))
 		deleteSessions := firstRun
+		// Jobs of a previous run of the runner are only finished once
+		finishStuckJobs := firstRun
 		firstRun = false
 		// No retry on Fatal failures, like runner was removed or we received multiple jobs
 		fatalFailure := false
 		for _, instance := range settings.Instances {
 			go func(instance *runnerconfiguration.RunnerInstance) (exitcode int) {
 				defer wg.Done()
+				ilogger := logger.WithFields(logrus.Fields{
+					common.LogFieldRunner: instance.Agent.Name,
+					common.LogFieldURL:    instance.RegistrationURL,
+				})
+				istatus := status.add(instance)
+				defer status.update(istatus, func(i *InstanceStatus) {
+					i.SessionID = ""
+				})
 				defer func() {
 					// Without this the inner return 1 got lost and we would retry it
 					if exitcode != 0 {
 						fatalFailure = true
 					}
 				}()
-				customTransport := http.DefaultTransport.(*http.Transport).Clone()
-				customTransport.MaxIdleConns = 1
-				customTransport.IdleConnTimeout = 100 * time.Second
-				if v, ok := common.LookupEnvBool("SKIP_TLS_CERT_VALIDATION"); ok && v {
-					customTransport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+				client, err := common.NewHttpClient()
+				if err != nil {
+					ilogger.Errorf("Invalid http configuration: %v", err)
+					return 1
 				}
 				vssConnection := &protocol.VssConnection{
-					Client: &http.Client{
-						Timeout:   100 * time.Second,
-						Transport: customTransport,
-					},
+					Client:    client,
 					TenantURL: instance.Auth.TenantURL,
 					PoolID:    instance.PoolID,
 					TaskAgent: instance.Agent,
 					Key:       instance.PKey,
 					Trace:     run.Trace,
+					Logger:    ilogger,
+					// The session, the job renewal and the job connections share the token
+					TokenSource: protocol.NewTokenSource(instance.Agent, instance.PKey, client),
 				}
-				jobrun := &JobRun{}
-				if runnerenv.ReadJson("jobrun.json", jobrun) == nil && ((jobrun.RegistrationURL == instance.RegistrationURL && jobrun.Name == instance.Agent.Name) || (len(settings.Instances) == 1)) {
-					result := "Failed"
-					finish := &protocol.JobEvent{
-						Name:      "JobCompleted",
-						JobID:     jobrun.JobID,
-						RequestID: jobrun.RequestID,
-						Result:    result,
-					}
-					go func() {
-						for i := 0; ; i++ {
-							if err := vssConnection.FinishJob(finish, jobrun.Plan); err != nil {
-								runnerenv.Printf("Failed to finish previous stuck job with Status Failed: %v\n", err.Error())
-							} else {
-								runnerenv.Printf("Finished previous stuck job with Status Failed\n")
-								break
-							}
-							if i < 10 {
-								runnerenv.Printf("Retry finishing the job in 10 seconds attempt %v of 10\n", i+1)
-								<-time.After(time.Second * 10)
-							} else {
-								break
+				if finishStuckJobs {
+					for _, jobrun := range journal.Pending(instance, settings.Instances) {
+						// Don't share the connection with the message loop
+						con := *vssConnection
+						jobCompletedWG.Add(1)
+						go func(jobrun *JobRun) {
+							defer jobCompletedWG.Done()
+							finishStuckJob(&con, jobrun)
+							if err := journal.Remove(jobrun); err != nil {
+								ilogger.Infof("Failed to update %v: %v", jobrunJournalFile, err)
 							}
-						}
-					}()
-					runnerenv.Remove("jobrun.json")
+						}(jobrun)
+					}
 				}
 				mu.Lock()
 				var _session *protocol.AgentMessageConnection = nil
@@ -216,7 +252,7 @@ func (run *RunRunner) Run(runnerenv RunnerEnvironment, listenerctx context.Conte
 						timeout, cancelT := context.WithTimeout(context.Background(), time.Minute)
 						defer cancelT()
 						if err := session.Delete(timeout); err != nil {
-							runnerenv.Printf("WARNING: Failed to delete active session: %v\n", err)
+							ilogger.WithField(common.LogFieldSessionID, session.TaskAgentSession.SessionID).Warnf("Failed to delete active session: %v", err)
 						} else {
 							mu.Lock()
 							for i, _session := range sessions {
@@ -235,8 +271,10 @@ func (run *RunRunner) Run(runnerenv RunnerEnvironment, listenerctx context.Conte
 				xctx, _c := context.WithCancel(joblisteningctx)
 				lastSuccess := time.Now()
 				defer _c()
+				sessionBackoff := &common.Backoff{Min: 5 * time.Second, Max: 5 * time.Minute}
+				pollBackoff := &common.Backoff{Min: time.Second, Max: time.Minute}
 				for {
-					message := &protocol.TaskAgentMessage{}
+					var message *protocol.TaskAgentMessage
 					success := false
 					for !success {
 						select {
@@ -244,95 +282,129 @@ func (run *RunRunner) Run(runnerenv RunnerEnvironment, listenerctx context.Conte
 							return 0
 						default:
 						}
+						// Don't ask for another job, while all job slots are in use
+						if scheduler.Running() >= scheduler.MaxParallel() {
+							status.setBusy(istatus, true)
+							if scheduler.WaitIdle(joblisteningctx) != nil {
+								return 0
+							}
+							status.setBusy(istatus, false)
+						}
 						if session == nil || time.Now().After(lastSuccess.Add(5*time.Minute)) {
+							status.update(istatus, func(i *InstanceStatus) {
+								i.SessionID = ""
+							})
 							deleteSession()
 							session2, err := vssConnection.CreateSession(joblisteningctx)
 							if err != nil {
-								if strings.Contains(err.Error(), "invalid_client") || strings.Contains(err.Error(), "TaskAgentNotFoundException") {
-									runnerenv.Printf("Fatal: It seems this runner was removed from GitHub, Failed to recreate Session for %v ( %v ): %v\n", instance.Agent.Name, instance.RegistrationURL, err.Error())
+								if protocol.IsServiceError(err, protocol.InvalidClient) || protocol.IsServiceError(err, protocol.TaskAgentNotFoundException) {
+									ilogger.Errorf("It seems this runner was removed from GitHub, Failed to recreate Session: %v", err.Error())
 									return 1
 								}
-								runnerenv.Printf("Failed to recreate Session for %v ( %v ), waiting 30 sec before retry: %v\n", instance.Agent.Name, instance.RegistrationURL, err.Error())
+								delay := sessionBackoff.Next()
+								if retryAfter := protocol.RetryAfter(err); retryAfter > delay {
+									delay = retryAfter
+								}
+								ilogger.Warnf("Failed to recreate Session, waiting %v before retry: %v", delay.Round(time.Second), err.Error())
 								select {
 								case <-joblisteningctx.Done():
 									return 0
-								case <-time.After(30 * time.Second):
+								case <-time.After(delay):
 								}
 								continue
 							} else if session2 != nil {
 								session = session2
+								sessionBackoff.Reset()
+								sessionsCreated.Inc(instance.Agent.Name, instance.RegistrationURL)
 								mu.Lock()
 								sessions = append(sessions, session.TaskAgentSession)
 								err := runnerenv.WriteJson("sessions.json", sessions)
+								slogger := ilogger.WithField(common.LogFieldSessionID, session.TaskAgentSession.SessionID)
 								if err != nil {
-									runnerenv.Printf("error: %v\n", err)
+									slogger.Errorf("Failed to update sessions.json: %v", err)
 								} else {
-									runnerenv.Printf("Listening for Jobs: %v ( %v )\n", instance.Agent.Name, instance.RegistrationURL)
+									slogger.Infof("Listening for Jobs")
 								}
 								mu.Unlock()
 							} else {
-								runnerenv.Printf("Failed to recreate Session, waiting 30 sec before retry\n")
+								delay := sessionBackoff.Next()
+								ilogger.Warnf("Failed to recreate Session, waiting %v before retry", delay.Round(time.Second))
 								select {
 								case <-joblisteningctx.Done():
 									return 0
-								case <-time.After(30 * time.Second):
+								case <-time.After(delay):
 								}
 								continue
 							}
 						}
-						err := vssConnection.RequestWithContext(xctx, "c3a054f6-7a8a-49c0-944e-3a8e5d7adfd7", "5.1-preview", "GET", map[string]string{
-							"poolId": fmt.Sprint(instance.PoolID),
-						}, map[string]string{
-							"sessionId": session.TaskAgentSession.SessionID,
-						}, nil, message)
-						//TODO lastMessageId=
+						status.update(istatus, func(i *InstanceStatus) {
+							i.SessionID = session.TaskAgentSession.SessionID
+						})
+						slogger := ilogger.WithField(common.LogFieldSessionID, session.TaskAgentSession.SessionID)
+						listener := session.Listener()
+						var err error
+						message, err = listener.Poll(xctx)
 						if err != nil {
 							if errors.Is(err, context.Canceled) {
 								return 0
 							} else if !errors.Is(err, io.EOF) {
-								if strings.Contains(err.Error(), "TaskAgentSessionExpiredException") {
-									runnerenv.Printf("Failed to get message, Session expired: %v\n", err.Error())
+								if protocol.IsServiceError(err, protocol.TaskAgentSessionExpiredException) {
+									pollErrors.Inc(instance.Agent.Name, instance.RegistrationURL, "TaskAgentSessionExpiredException")
+									sessionsExpired.Inc(instance.Agent.Name, instance.RegistrationURL)
+									slogger.Warnf("Failed to get message, Session expired: %v", err.Error())
 									session = nil
 									continue
-								} else if strings.Contains(err.Error(), "AccessDeniedException") {
-									runnerenv.Printf("Failed to get message, GitHub has rejected our authorization, recreate Session earlier: %v\n", err.Error())
+								} else if protocol.IsServiceError(err, protocol.AccessDeniedException) {
+									pollErrors.Inc(instance.Agent.Name, instance.RegistrationURL, "AccessDeniedException")
+									slogger.Warnf("Failed to get message, GitHub has rejected our authorization, recreate Session earlier: %v", err.Error())
 									session = nil
 									continue
 								} else {
-									runnerenv.Printf("Failed to get message, waiting 10 sec before retry: %v\n", err.Error())
+									pollErrors.Inc(instance.Agent.Name, instance.RegistrationURL, "Other")
+									delay := pollBackoff.Next()
+									if retryAfter := protocol.RetryAfter(err); retryAfter > delay {
+										delay = retryAfter
+									}
+									slogger.Warnf("Failed to get message, waiting %v before retry: %v", delay.Round(time.Second), err.Error())
 									select {
 									case <-joblisteningctx.Done():
 										return 0
-									case <-time.After(10 * time.Second):
+									case <-time.After(delay):
 									}
 								}
 							} else {
+								pollErrors.Inc(instance.Agent.Name, instance.RegistrationURL, "EOF")
+								pollBackoff.Reset()
 								lastSuccess = time.Now()
+								status.update(istatus, func(i *InstanceStatus) {
+									i.LastSuccess = lastSuccess
+								})
 							}
 						} else {
+							pollBackoff.Reset()
 							lastSuccess = time.Now()
+							status.update(istatus, func(i *InstanceStatus) {
+								i.LastSuccess = lastSuccess
+							})
 							if firstJobReceived && (strings.EqualFold(message.MessageType, "PipelineAgentJobRequest") || strings.EqualFold(message.MessageType, "RunnerJobRequest")) {
 								// It seems run once isn't supported by the backend, do the same as the official runner
 								// Skip deleting the job message and cancel earlier
-								runnerenv.Printf("Received a second job, but running in run once mode abort\n")
+								slogger.Errorf("Received a second job, but running in run once mode abort")
 								return 1
 							}
 							success = true
-							err := vssConnection.Request("c3a054f6-7a8a-49c0-944e-3a8e5d7adfd7", "5.1-preview", "DELETE", map[string]string{
-								"poolId":    fmt.Sprint(instance.PoolID),
-								"messageId": fmt.Sprint(message.MessageID),
-							}, map[string]string{
-								"sessionId": session.TaskAgentSession.SessionID,
-							}, nil, nil)
-							if err != nil {
-								runnerenv.Printf("Failed to delete Message\n")
-								success = false
+							// The listener won't return the message again, even if the actions service delivers it again
+							if err := listener.Acknowledge(joblisteningctx, message); err != nil {
+								slogger.Warnf("Failed to delete Message %v: %v", message.MessageID, err)
 							}
 						}
 					}
 					if success {
 						if message != nil && (strings.EqualFold(message.MessageType, "PipelineAgentJobRequest") || strings.EqualFold(message.MessageType, "RunnerJobRequest")) {
-							cancelJobListening()
+							if run.Once {
+								cancelJobListening()
+							}
+							status.setBusy(istatus, true)
 							for message != nil && !firstJobReceived && (strings.EqualFold(message.MessageType, "PipelineAgentJobRequest") || strings.EqualFold(message.MessageType, "RunnerJobRequest")) {
 								if run.Once {
 									firstJobReceived = true
@@ -344,35 +416,36 @@ func (run *RunRunner) Run(runnerenv RunnerEnvironment, listenerctx context.Conte
 									<-jobctx.Done()
 									jobCompletedWG.Done()
 								}()
-								runJob(runnerenv, &joblock, vssConnection, run, cancel, cancelJob, finishJob, jobExecCtx, jobctx, session, *message, instance)
+								runJob(runnerenv, ilogger, scheduler, journal, vssConnection, run, stopListening, cancelJob, finishJob, jobExecCtx, jobctx, session, *message, instance)
 								{
 									var err error
 									message, err = session.GetNextMessage(jobExecCtx)
 									if !errors.Is(err, context.Canceled) && message != nil {
 										if firstJobReceived && (strings.EqualFold(message.MessageType, "PipelineAgentJobRequest") || strings.EqualFold(message.MessageType, "RunnerJobRequest")) {
-											runnerenv.Printf("Skip deleting the duplicated job request, we hope that the actions service reschedules your job to a different runner\n")
+											ilogger.Warnf("Skip deleting the duplicated job request, we hope that the actions service reschedules your job to a different runner")
 										} else {
-											session.DeleteMessage(joblisteningctx, message)
+											session.Listener().Acknowledge(joblisteningctx, message)
 										}
 										if strings.EqualFold(message.MessageType, "JobCancellation") && cancelJob != nil {
 											message = nil
-											runnerenv.Printf("JobCancellation request received, cancel running job\n")
+											ilogger.Infof("JobCancellation request received, cancel running job")
 											cancelJob()
 										} else {
-											runnerenv.Printf("Received message, while still executing a job, of type: %v\n", message.MessageType)
+											ilogger.Infof("Received message, while still executing a job, of type: %v", message.MessageType)
 										}
-										runnerenv.Printf("Wait for worker to finish current job\n")
+										ilogger.Infof("Wait for worker to finish current job")
 										<-jobctx.Done()
 									}
 								}
 							}
+							status.setBusy(istatus, false)
 							// Skip deleting session for ephemeral, since the official actions service throws access denied
 							if !run.Once || isEphemeral {
 								session = nil
 							}
 						}
 						if message != nil {
-							runnerenv.Printf("Ignoring incoming message of type: %v\n", message.MessageType)
+							ilogger.Infof("Ignoring incoming message of type: %v", message.MessageType)
 						}
 					}
 				}
@@ -406,16 +479,22 @@ func (f *plainTextFormatter) Format(entry *logrus.Entry) ([]byte, error) {
 	return []byte(entry.Time.UTC().Format(protocol.TimestampOutputFormat) + " " + entry.Message + "\n"), nil
 }
 
-func runJob(runnerenv RunnerEnvironment, joblock *sync.Mutex, vssConnection *protocol.VssConnection, run *RunRunner, cancel context.CancelFunc, cancelJob context.CancelFunc, finishJob context.CancelFunc, jobExecCtx context.Context, jobctx context.Context, session *protocol.AgentMessageConnection, message protocol.TaskAgentMessage, instance *runnerconfiguration.RunnerInstance) {
+func runJob(runnerenv RunnerEnvironment, ilogger logrus.FieldLogger, scheduler *jobScheduler, journal *jobrunJournal, vssConnection *protocol.VssConnection, run *RunRunner, cancel context.CancelFunc, cancelJob context.CancelFunc, finishJob context.CancelFunc, jobExecCtx context.Context, jobctx context.Context, session *protocol.AgentMessageConnection, message protocol.TaskAgentMessage, instance *runnerconfiguration.RunnerInstance) {
 	go func() {
-		plogger := &PrefixConsoleLogger{
-			Parent: runnerenv,
-			Prefix: fmt.Sprintf("%v ( %v ):", instance.Agent.Name, instance.RegistrationURL),
-		}
+		started := time.Now()
+		result := "Unknown"
+		jobsStarted.Inc(instance.Agent.Name, instance.RegistrationURL)
+		jobsRunning.Add(1)
+		defer func() {
+			jobsRunning.Add(-1)
+			jobsFinished.Inc(instance.Agent.Name, instance.RegistrationURL, result)
+			jobDuration.Observe(time.Since(started).Seconds(), instance.Agent.Name, instance.RegistrationURL, result)
+		}()
+		plogger := ilogger
 		defer func() {
 			if run.Once {
 				// cancel Message Loop
-				plogger.Printf("Last Job finished, cancel Message loop\n")
+				plogger.Infof("Last Job finished, cancel Message loop")
 				cancel()
 			}
 			cancelJob()
@@ -423,48 +502,54 @@ func runJob(runnerenv RunnerEnvironment, joblock *sync.Mutex, vssConnection *pro
 		}()
 		src, err := message.Decrypt(session.Block)
 		if err != nil {
-			plogger.Printf("Failed to decode TaskAgentMessage: %v\n", err)
+			plogger.Errorf("Failed to decode TaskAgentMessage: %v", err)
 			return
 		}
-		if run.Trace {
-			plogger.Printf("%v\n", string(src))
-		}
+		plogger.Debugf("%v", string(src))
 		jobreq := &protocol.AgentJobRequestMessage{}
 		var runServiceUrl string
 		{
 			if strings.EqualFold(message.MessageType, "RunnerJobRequest") {
-				plogger.Printf("Warning: TaskAgentMessage.MessageType is %v, which has not been properly tested due to missing access to test servers of the new protocol before rollout. Please report any failures to https://github.com/ChristopherHX/github-act-runner/issues.\n", message.MessageType)
+				plogger.Warnf("TaskAgentMessage.MessageType is %v, which has not been properly tested due to missing access to test servers of the new protocol before rollout. Please report any failures to https://github.com/ChristopherHX/github-act-runner/issues.", message.MessageType)
 				rjrr := &RunnerJobRequestRef{}
 				json.Unmarshal(src, rjrr)
-				for retries := 0; retries < 5; retries++ {
-					var err error
-					if len(rjrr.RunServiceUrl) == 0 {
-						err = vssConnection.RequestWithContext(jobctx, "25adab70-1379-4186-be8e-b643061ebe3a", "6.0-preview", "GET", map[string]string{
-							"messageId": rjrr.RunnerRequestId,
-						}, map[string]string{}, nil, &src)
-					} else {
-						copy := *vssConnection
-						vssConnection = &copy
-						runServiceUrl = rjrr.RunServiceUrl
-						acquirejobUrl, _ := url.Parse(runServiceUrl)
-						acquirejobUrl.Path = path.Join(acquirejobUrl.Path, "acquirejob")
-						vssConnection.TenantURL = runServiceUrl
-						payload := &runservice.AcquireJobRequest{
-							StreamID:     rjrr.RunnerRequestId,
-							JobMessageID: rjrr.RunnerRequestId,
-						}
-						err = vssConnection.RequestWithContext2(jobctx, "POST", acquirejobUrl.String(), "", payload, &src)
-					}
-					if err == nil {
-						json.Unmarshal(src, jobreq)
-						break
+				var err error
+				if len(rjrr.RunServiceUrl) == 0 {
+					err = vssConnection.RequestWithContext(jobctx, "25adab70-1379-4186-be8e-b643061ebe3a", "6.0-preview", "GET", map[string]string{
+						"messageId": rjrr.RunnerRequestId,
+					}, map[string]string{}, nil, &src)
+				} else {
+					copy := *vssConnection
+					vssConnection = &copy
+					runServiceUrl = rjrr.RunServiceUrl
+					acquirejobUrl, _ := url.Parse(runServiceUrl)
+					acquirejobUrl.Path = path.Join(acquirejobUrl.Path, "acquirejob")
+					vssConnection.TenantURL = runServiceUrl
+					payload := &runservice.AcquireJobRequest{
+						StreamID:     rjrr.RunnerRequestId,
+						JobMessageID: rjrr.RunnerRequestId,
 					}
-					<-time.After(time.Second * 5 * time.Duration(retries+1))
+					// The job is lost if it cannot be acquired
+					acquireConnection := *vssConnection
+					acquireConnection.RetryPolicy = protocol.PersistentRetryPolicy
+					err = acquireConnection.RequestWithContext2(jobctx, "POST", acquirejobUrl.String(), "", payload, &src)
+				}
+				if err == nil {
+					json.Unmarshal(src, jobreq)
+				} else {
+					plogger.Errorf("Failed to acquire the job: %v", err.Error())
 				}
 			} else {
 				json.Unmarshal(src, jobreq)
 			}
 		}
+		plogger = plogger.WithFields(logrus.Fields{
+			common.LogFieldJobID:     jobreq.JobID,
+			common.LogFieldRequestID: jobreq.RequestID,
+		})
+		jobConnection := *vssConnection
+		jobConnection.Logger = plogger
+		vssConnection = &jobConnection
 		jobrun := &JobRun{
 			RequestID:       jobreq.RequestID,
 			JobID:           jobreq.JobID,
@@ -473,27 +558,23 @@ func runJob(runnerenv RunnerEnvironment, joblock *sync.Mutex, vssConnection *pro
 			Name:            instance.Agent.Name,
 			RunServiceURL:   runServiceUrl,
 		}
-		{
-			// TODO multi repository runners can receive multiple job requests at the same time and this protection doesn't work there
-			if err := runnerenv.WriteJson("jobrun.json", jobrun); err != nil {
-				plogger.Printf("INFO: Failed to create jobrun.json: %v\n", err)
-			}
+		if err := journal.Add(jobrun); err != nil {
+			plogger.Infof("Failed to update %v: %v", jobrunJournalFile, err)
 		}
 		con := *vssConnection
 		go func() {
 			for {
 				var err error
 				if runServiceUrl != "" {
-					vssConnection = &con
 					renewjobUrl, _ := url.Parse(runServiceUrl)
 					renewjobUrl.Path = path.Join(renewjobUrl.Path, "renewjob")
-					vssConnection.TenantURL = runServiceUrl
+					con.TenantURL = runServiceUrl
 					payload := &runservice.RenewJobRequest{
 						PlanID: jobreq.Plan.PlanID,
 						JobID:  jobreq.JobID,
 					}
 					resp := &runservice.RenewJobResponse{}
-					err = vssConnection.RequestWithContext2(jobctx, "POST", renewjobUrl.String(), "", payload, &resp)
+					err = con.RequestWithContext2(jobctx, "POST", renewjobUrl.String(), "", payload, &resp)
 				} else {
 					err = con.RequestWithContext(jobctx, "fc825784-c92a-4299-9221-998a02d1b54f", "5.1-preview", "PATCH", map[string]string{
 						"poolId":    fmt.Sprint(instance.PoolID),
@@ -506,7 +587,8 @@ func runJob(runnerenv RunnerEnvironment, joblock *sync.Mutex, vssConnection *pro
 					if errors.Is(err, context.Canceled) {
 						return
 					} else {
-						plogger.Printf("Failed to renew job: %v\n", err.Error())
+						renewFailures.Inc(instance.Agent.Name, instance.RegistrationURL)
+						plogger.Warnf("Failed to renew job: %v", err.Error())
 					}
 				}
 				select {
@@ -516,12 +598,16 @@ func runJob(runnerenv RunnerEnvironment, joblock *sync.Mutex, vssConnection *pro
 				}
 			}
 		}()
-		plogger.Printf("Running Job '%v'\n", jobreq.JobDisplayName)
+		plogger.Infof("Running Job '%v'", jobreq.JobDisplayName)
 		wc := &DefaultWorkerContext{
 			RunnerMessage:       jobreq,
 			JobExecutionContext: jobExecCtx,
 			VssConnection:       vssConnection,
 			RunnerLogger:        plogger,
+			ExecutionProfile:    instance.Profile,
+			OnFinishJob: func(r string) {
+				result = r
+			},
 		}
 		wc.Init()
 		jlogger := wc.Logger()
@@ -535,7 +621,9 @@ func runJob(runnerenv RunnerEnvironment, joblock *sync.Mutex, vssConnection *pro
 			if err := recover(); err != nil {
 				wc.FailInitJob("Worker panicked", "The worker panicked with message: "+fmt.Sprint(err)+"\n"+string(debug.Stack()))
 			}
-			runnerenv.Remove("jobrun.json")
+			if err := journal.Remove(jobrun); err != nil {
+				plogger.Infof("Failed to update %v: %v", jobrunJournalFile, err)
+			}
 		}()
 
 		logger := logrus.New()
@@ -551,39 +639,44 @@ func runJob(runnerenv RunnerEnvironment, joblock *sync.Mutex, vssConnection *pro
 			logger.Log(logrus.InfoLevel, "Runner Version: "+run.Version)
 		}
 
-		// Wait for possible concurrent running job and serialize, this only happens for multi repository runners
-		waitContext, finishWait := context.WithCancel(jobExecCtx)
-		defer finishWait()
-		go func() {
-			for {
-				select {
-				case <-waitContext.Done():
-					return
-				case <-time.After(1 * time.Second):
-					logger.Log(logrus.InfoLevel, "Waiting for runner to complete active job")
+		// Wait for a free job slot, this only happens for multi repository runners
+		slot := scheduler.TryAcquire()
+		if slot < 0 {
+			waitContext, finishWait := context.WithCancel(jobExecCtx)
+			go func() {
+				for {
+					logger.Log(logrus.InfoLevel, fmt.Sprintf("Waiting for a free job slot, all %v are in use", scheduler.MaxParallel()))
+					select {
+					case <-waitContext.Done():
+						return
+					case <-time.After(time.Minute):
+					}
 				}
-			}
-		}()
-		joblch := make(chan struct{})
-		go func() {
-			joblock.Lock()
-			defer func() {
-				joblock.Unlock()
 			}()
-			close(joblch)
-			<-jobctx.Done()
-		}()
-		select {
-		case <-joblch:
+			var err error
+			slot, err = scheduler.Acquire(waitContext)
 			finishWait()
-		case <-jobExecCtx.Done():
+			if err != nil {
+				// The job got cancelled before it could start
+				logger.Log(logrus.InfoLevel, "The job has been cancelled while waiting for a free job slot")
+				completeJob(wc, "Canceled", "Canceled")
+				return
+			}
+		}
+		defer scheduler.Release(slot)
+		// A runner instance only runs one job at a time, concurrent jobs of other instances use their own work folder
+		workspace, err := PrepareWorkspace(instanceWorkFolder(instance), jobreq)
+		if err != nil {
+			wc.FailInitJob("Failed to prepare the workspace", err.Error())
+			return
 		}
-		// The following code is synchronized
+		wc.JobWorkspace = workspace
+		wc.WorkDirectory = workspace.Workspace
 		err = runnerenv.ExecWorker(run, wc, jobreq, src)
 		if err != nil {
 			wc.FailInitJob("Worker Failed", err.Error())
 		} else {
-			plogger.Printf("Finished Job '%v'\n", jobreq.JobDisplayName)
+			plogger.Infof("Finished Job '%v'", jobreq.JobDisplayName)
 		}
 	}()
 }
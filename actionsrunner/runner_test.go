@@ -0,0 +1,317 @@
+package actionsrunner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ChristopherHX/github-act-runner/metrics"
+	"github.com/ChristopherHX/github-act-runner/protocol"
+	"github.com/ChristopherHX/github-act-runner/protocol/fakeservice"
+	"github.com/ChristopherHX/github-act-runner/runnerconfiguration"
+	"github.com/stretchr/testify/assert"
+)
+
+type testRunnerEnvironment struct {
+	t     *testing.T
+	mu    sync.Mutex
+	files map[string][]byte
+	exec  func(wc WorkerContext) error
+}
+
+func (env *testRunnerEnvironment) Printf(format string, a ...interface{}) {
+	env.t.Logf(format, a...)
+}
+
+func (env *testRunnerEnvironment) ReadJson(fname string, obj interface{}) error {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	content, ok := env.files[fname]
+	if !ok {
+		return &os.PathError{Op: "open", Path: fname, Err: os.ErrNotExist}
+	}
+	return json.Unmarshal(content, obj)
+}
+
+func (env *testRunnerEnvironment) WriteJson(fname string, obj interface{}) error {
+	content, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	env.files[fname] = content
+	return nil
+}
+
+func (env *testRunnerEnvironment) Remove(fname string) error {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	if _, ok := env.files[fname]; !ok {
+		return &os.PathError{Op: "remove", Path: fname, Err: os.ErrNotExist}
+	}
+	delete(env.files, fname)
+	return nil
+}
+
+func (env *testRunnerEnvironment) Exists(fname string) bool {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	_, ok := env.files[fname]
+	return ok
+}
+
+func (env *testRunnerEnvironment) ExecWorker(run *RunRunner, wc WorkerContext, jobreq *protocol.AgentJobRequestMessage, src []byte) error {
+	return env.exec(wc)
+}
+
+func runTestJob(wc WorkerContext, result string) {
+	wc.Logger().Log("Hello World")
+	completeJob(wc, "Succeeded", result)
+}
+
+func newTestRunner(t *testing.T, server *fakeservice.Server, names ...string) (*RunRunner, *testRunnerEnvironment) {
+	settings := &runnerconfiguration.RunnerSettings{}
+	for _, name := range names {
+		instance, err := server.AddRunner(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		instance.WorkFolder = t.TempDir()
+		settings.Instances = append(settings.Instances, instance)
+	}
+	return &RunRunner{Settings: settings}, &testRunnerEnvironment{t: t, files: map[string][]byte{}}
+}
+
+func TestRunOnce(t *testing.T) {
+	server := fakeservice.NewServer()
+	defer server.Close()
+	run, env := newTestRunner(t, server, "runner")
+	run.Once = true
+	env.exec = func(wc WorkerContext) error {
+		assert.True(t, env.Exists(jobrunJournalFile), "the job has to be tracked while running")
+		runTestJob(wc, "Succeeded")
+		return nil
+	}
+	jobreq := server.NewJob("build")
+	assert.NoError(t, server.QueueJob("runner", jobreq))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	assert.NoError(t, run.Run(env, ctx, ctx))
+
+	finished := server.FinishedJobs()
+	if assert.Len(t, finished, 1) {
+		assert.Equal(t, jobreq.JobID, finished[0].JobID)
+		assert.Equal(t, "Succeeded", finished[0].Result)
+	}
+	timeline := server.Timeline(jobreq.Timeline.ID)
+	if assert.Len(t, timeline, 2) {
+		assert.Equal(t, "Set up Worker", timeline[1].Name)
+		assert.Equal(t, "Succeeded", *timeline[1].Result)
+		if assert.NotNil(t, timeline[1].Log) {
+			assert.Contains(t, server.Log(timeline[1].Log.ID), "Hello World")
+		}
+	}
+	assert.False(t, env.Exists(jobrunJournalFile))
+	assert.Equal(t, 0, server.Sessions())
+
+	buf := &bytes.Buffer{}
+	_, _ = metrics.DefaultRegistry.WriteTo(buf)
+	assert.Contains(t, buf.String(), fmt.Sprintf("github_act_runner_jobs_finished_total{runner=\"runner\",url=\"%v\",result=\"Succeeded\"} 1", run.Settings.Instances[0].RegistrationURL))
+}
+
+func TestRunOnceRunService(t *testing.T) {
+	server := fakeservice.NewServer()
+	defer server.Close()
+	run, env := newTestRunner(t, server, "runner")
+	run.Once = true
+	jobreq := server.NewJob("build")
+	env.exec = func(wc WorkerContext) error {
+		for i := 0; i < 100 && server.Renewals(jobreq.JobID) == 0; i++ {
+			time.Sleep(100 * time.Millisecond)
+		}
+		cur := wc.Logger().Current()
+		cur.Issues = append(cur.Issues, protocol.Issue{Type: "error", Message: "build failed", Data: map[string]string{"file": "main.go", "line": "3", "title": "Build"}})
+		cur.ErrorCount++
+		runTestJob(wc, "Failed")
+		return nil
+	}
+	assert.NoError(t, server.QueueRunServiceJob("runner", jobreq))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	assert.NoError(t, run.Run(env, ctx, ctx))
+
+	completed := server.CompletedJobs()
+	if assert.Len(t, completed, 1) {
+		assert.Equal(t, jobreq.JobID, completed[0].JobID)
+		assert.Equal(t, "Failed", completed[0].Conclusion)
+		if assert.Len(t, completed[0].StepResults, 1) && assert.Len(t, completed[0].StepResults[0].Annotations, 1) {
+			annotation := completed[0].StepResults[0].Annotations[0]
+			assert.Equal(t, "build failed", annotation.Message)
+			assert.Equal(t, "Build", annotation.Title)
+			assert.Equal(t, "main.go", annotation.Path)
+			assert.Equal(t, int64(3), annotation.StartLine)
+		}
+		assert.Len(t, completed[0].Annotations, 1)
+	}
+	assert.Empty(t, server.FinishedJobs())
+	assert.NotZero(t, server.Renewals(jobreq.JobID))
+}
+
+func TestRunConcurrentJobs(t *testing.T) {
+	server := fakeservice.NewServer()
+	defer server.Close()
+	run, env := newTestRunner(t, server, "runner1", "runner2")
+	run.MaxParallel = 2
+	var started sync.WaitGroup
+	started.Add(2)
+	workFolders := make(chan string, 2)
+	env.exec = func(wc WorkerContext) error {
+		workFolders <- wc.Workspace().WorkFolder
+		// Both jobs have to run at the same time to get past this point
+		started.Done()
+		started.Wait()
+		runTestJob(wc, "Succeeded")
+		return nil
+	}
+	job1 := server.NewJob("build1")
+	job2 := server.NewJob("build2")
+	assert.NoError(t, server.QueueJob("runner1", job1))
+	assert.NoError(t, server.QueueJob("runner2", job2))
+
+	listenerctx, stopListener := context.WithCancel(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	done := make(chan error)
+	go func() {
+		done <- run.Run(env, listenerctx, ctx)
+	}()
+	for _, job := range []*protocol.AgentJobRequestMessage{job1, job2} {
+		result, err := server.WaitForJob(job.JobID, 30*time.Second)
+		assert.NoError(t, err)
+		assert.Equal(t, "Succeeded", result)
+	}
+	stopListener()
+	assert.NoError(t, <-done)
+	// Every job runs in the work folder of its runner instance
+	assert.ElementsMatch(t, []string{run.Settings.Instances[0].WorkFolder, run.Settings.Instances[1].WorkFolder}, []string{<-workFolders, <-workFolders})
+}
+
+func TestRunFinishesStuckJobs(t *testing.T) {
+	server := fakeservice.NewServer()
+	defer server.Close()
+	run, env := newTestRunner(t, server, "runner1", "runner2")
+	job1 := server.NewJob("build1")
+	job2 := server.NewJob("build2")
+	job3 := server.NewJob("build3")
+	unreconcilable := &JobRun{
+		RequestID:       1000,
+		JobID:           "unreconcilable",
+		Name:            "runner4",
+		RegistrationURL: "https://github.com/other/repo",
+	}
+	journal := []*JobRun{
+		{
+			RequestID:       job1.RequestID,
+			JobID:           job1.JobID,
+			Plan:            job1.Plan,
+			Name:            "runner1",
+			RegistrationURL: run.Settings.Instances[0].RegistrationURL,
+		},
+		{
+			RequestID:       job2.RequestID,
+			JobID:           job2.JobID,
+			Plan:            job2.Plan,
+			Name:            "runner2",
+			RegistrationURL: run.Settings.Instances[1].RegistrationURL,
+			RunServiceURL:   server.URL + fakeservice.RunServicePath,
+		},
+		{
+			// The runner has been removed, another runner of the same registration url finishes the job
+			RequestID:       job3.RequestID,
+			JobID:           job3.JobID,
+			Plan:            job3.Plan,
+			Name:            "runner3",
+			RegistrationURL: run.Settings.Instances[0].RegistrationURL,
+		},
+		unreconcilable,
+	}
+	assert.NoError(t, env.WriteJson(jobrunJournalFile, journal))
+
+	listenerctx, stopListener := context.WithCancel(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	done := make(chan error)
+	go func() {
+		done <- run.Run(env, listenerctx, ctx)
+	}()
+	for _, job := range []*protocol.AgentJobRequestMessage{job1, job2, job3} {
+		result, err := server.WaitForJob(job.JobID, 30*time.Second)
+		assert.NoError(t, err)
+		assert.Equal(t, "Failed", result)
+	}
+	assert.Len(t, server.FinishedJobs(), 2)
+	assert.Len(t, server.CompletedJobs(), 1)
+	stopListener()
+	assert.NoError(t, <-done)
+	var remaining []*JobRun
+	assert.NoError(t, env.ReadJson(jobrunJournalFile, &remaining))
+	assert.Equal(t, []*JobRun{unreconcilable}, remaining, "jobs without a runner of the same registration url are kept")
+}
+
+func TestRunExecutionProfiles(t *testing.T) {
+	server := fakeservice.NewServer()
+	defer server.Close()
+	run, env := newTestRunner(t, server, "act", "external")
+	actWorkFolder := filepath.Join(t.TempDir(), "act")
+	run.Settings.Instances[0].Profile = &runnerconfiguration.ExecutionProfile{Worker: runnerconfiguration.WorkerAct, WorkFolder: actWorkFolder}
+	run.Settings.Instances[1].Profile = &runnerconfiguration.ExecutionProfile{WorkerArgs: []string{"python3", "compat/actions-runner-worker.py", "bin/Runner.Worker"}}
+	var mu sync.Mutex
+	profiles := map[string]WorkerContext{}
+	env.exec = func(wc WorkerContext) error {
+		mu.Lock()
+		profiles[wc.Message().JobDisplayName] = wc
+		mu.Unlock()
+		runTestJob(wc, "Succeeded")
+		return nil
+	}
+	job1 := server.NewJob("build1")
+	job2 := server.NewJob("build2")
+	assert.NoError(t, server.QueueJob("act", job1))
+	assert.NoError(t, server.QueueJob("external", job2))
+
+	listenerctx, stopListener := context.WithCancel(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	done := make(chan error)
+	go func() {
+		done <- run.Run(env, listenerctx, ctx)
+	}()
+	for _, job := range []*protocol.AgentJobRequestMessage{job1, job2} {
+		_, err := server.WaitForJob(job.JobID, 30*time.Second)
+		assert.NoError(t, err)
+	}
+	stopListener()
+	assert.NoError(t, <-done)
+
+	if assert.Contains(t, profiles, "build1") {
+		args, err := profiles["build1"].Profile().WorkerCommand([]string{"worker"})
+		assert.NoError(t, err)
+		assert.Nil(t, args, "the act worker ignores --worker-args")
+		assert.Equal(t, filepath.Join(actWorkFolder, "workspace", "workspace"), profiles["build1"].WorkDir())
+	}
+	if assert.Contains(t, profiles, "build2") {
+		args, err := profiles["build2"].Profile().WorkerCommand(nil)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"python3", "compat/actions-runner-worker.py", "bin/Runner.Worker"}, args)
+		assert.Equal(t, filepath.Join(run.Settings.Instances[1].WorkFolder, "workspace", "workspace"), profiles["build2"].WorkDir())
+	}
+}
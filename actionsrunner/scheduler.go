@@ -0,0 +1,105 @@
+package actionsrunner
+
+import (
+	"context"
+	"sync"
+)
+
+// jobScheduler limits the number of jobs executed at the same time by all instances of a runner process
+type jobScheduler struct {
+	mu      sync.Mutex
+	slots   []bool
+	running int
+	changed chan struct{}
+}
+
+func newJobScheduler(maxParallel int) *jobScheduler {
+	if maxParallel <= 0 {
+		maxParallel = 1
+	}
+	return &jobScheduler{
+		slots:   make([]bool, maxParallel),
+		changed: make(chan struct{}),
+	}
+}
+
+// MaxParallel returns the number of job slots
+func (s *jobScheduler) MaxParallel() int {
+	return len(s.slots)
+}
+
+// Running returns the number of occupied job slots
+func (s *jobScheduler) Running() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.running
+}
+
+// TryAcquire occupies a free job slot and returns its index, it returns -1 if all slots are in use
+func (s *jobScheduler) TryAcquire() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tryAcquire()
+}
+
+func (s *jobScheduler) tryAcquire() int {
+	for i, used := range s.slots {
+		if !used {
+			s.slots[i] = true
+			s.running++
+			return i
+		}
+	}
+	return -1
+}
+
+// Acquire blocks until a job slot is free and returns the index of the occupied slot
+func (s *jobScheduler) Acquire(ctx context.Context) (int, error) {
+	for {
+		s.mu.Lock()
+		if slot := s.tryAcquire(); slot >= 0 {
+			s.mu.Unlock()
+			return slot, nil
+		}
+		changed := s.changed
+		s.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return -1, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// Release frees a job slot returned by Acquire
+func (s *jobScheduler) Release(slot int) {
+	if slot < 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if slot < len(s.slots) && s.slots[slot] {
+		s.slots[slot] = false
+		s.running--
+		close(s.changed)
+		s.changed = make(chan struct{})
+	}
+}
+
+// WaitIdle blocks until at least one job slot is free, without occupying it
+func (s *jobScheduler) WaitIdle(ctx context.Context) error {
+	for {
+		s.mu.Lock()
+		if s.running < len(s.slots) {
+			s.mu.Unlock()
+			return nil
+		}
+		changed := s.changed
+		s.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
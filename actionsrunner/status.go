@@ -0,0 +1,132 @@
+package actionsrunner
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ChristopherHX/github-act-runner/runnerconfiguration"
+)
+
+// DefaultHealthTimeout is the time after the last successful message poll, until an idle runner instance is reported as unhealthy
+const DefaultHealthTimeout = 5 * time.Minute
+
+// InstanceStatus is the state of the message loop of a single runner instance
+type InstanceStatus struct {
+	Name            string    `json:"name"`
+	RegistrationURL string    `json:"registration_url"`
+	SessionID       string    `json:"session_id,omitempty"`
+	LastSuccess     time.Time `json:"last_success"`
+	// Busy is true while the instance doesn't poll for messages, because it executes a job or waits for a free job slot
+	Busy      bool      `json:"busy"`
+	IdleSince time.Time `json:"idle_since"`
+}
+
+// Status tracks the message loops of all runner instances for health and readiness checks
+type Status struct {
+	// HealthTimeout overrides DefaultHealthTimeout
+	HealthTimeout time.Duration
+	mu            sync.Mutex
+	instances     []*InstanceStatus
+}
+
+func (s *Status) add(instance *runnerconfiguration.RunnerInstance) *InstanceStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, i := range s.instances {
+		if i.Name == instance.Agent.Name && i.RegistrationURL == instance.RegistrationURL {
+			return i
+		}
+	}
+	i := &InstanceStatus{
+		Name:            instance.Agent.Name,
+		RegistrationURL: instance.RegistrationURL,
+		LastSuccess:     time.Now(),
+		IdleSince:       time.Now(),
+	}
+	s.instances = append(s.instances, i)
+	return i
+}
+
+func (s *Status) update(i *InstanceStatus, update func(i *InstanceStatus)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	update(i)
+}
+
+func (s *Status) setBusy(i *InstanceStatus, busy bool) {
+	s.update(i, func(i *InstanceStatus) {
+		if i.Busy && !busy {
+			i.IdleSince = time.Now()
+		}
+		i.Busy = busy
+	})
+}
+
+// Instances returns a copy of the state of all runner instances
+func (s *Status) Instances() []InstanceStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	instances := make([]InstanceStatus, len(s.instances))
+	for i, instance := range s.instances {
+		instances[i] = *instance
+	}
+	return instances
+}
+
+// Ready is true if every runner instance has an active message session
+func (s *Status) Ready() bool {
+	instances := s.Instances()
+	for _, i := range instances {
+		if i.SessionID == "" {
+			return false
+		}
+	}
+	return len(instances) > 0
+}
+
+// Healthy is true if every idle runner instance had a successful message poll within the HealthTimeout
+func (s *Status) Healthy() bool {
+	timeout := s.HealthTimeout
+	if timeout <= 0 {
+		timeout = DefaultHealthTimeout
+	}
+	for _, i := range s.Instances() {
+		last := i.LastSuccess
+		if i.IdleSince.After(last) {
+			last = i.IdleSince
+		}
+		if !i.Busy && time.Since(last) > timeout {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Status) writeStatus(w http.ResponseWriter, ok bool) {
+	w.Header().Set("Content-Type", "application/json")
+	if ok {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"ok":        ok,
+		"instances": s.Instances(),
+	})
+}
+
+// HealthHandler serves the result of Healthy, for the /healthz endpoint
+func (s *Status) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.writeStatus(w, s.Healthy())
+	})
+}
+
+// ReadyHandler serves the result of Ready, for the /readyz endpoint
+func (s *Status) ReadyHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.writeStatus(w, s.Ready())
+	})
+}
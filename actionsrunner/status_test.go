@@ -0,0 +1,46 @@
+package actionsrunner
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ChristopherHX/github-act-runner/protocol"
+	"github.com/ChristopherHX/github-act-runner/runnerconfiguration"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatus(t *testing.T) {
+	status := &Status{HealthTimeout: time.Minute}
+	assert.False(t, status.Ready(), "no runner instance has been started yet")
+
+	i := status.add(&runnerconfiguration.RunnerInstance{
+		RegistrationURL: "https://github.com/owner/repo",
+		Agent:           &protocol.TaskAgent{Name: "runner"},
+	})
+	assert.False(t, status.Ready())
+	assert.True(t, status.Healthy())
+
+	status.update(i, func(i *InstanceStatus) {
+		i.SessionID = "session"
+	})
+	rec := httptest.NewRecorder()
+	status.ReadyHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"session_id":"session"`)
+
+	status.update(i, func(i *InstanceStatus) {
+		i.LastSuccess = time.Now().Add(-time.Hour)
+		i.IdleSince = i.LastSuccess
+	})
+	rec = httptest.NewRecorder()
+	status.HealthHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	// A runner instance doesn't poll while executing a job
+	status.setBusy(i, true)
+	assert.True(t, status.Healthy())
+	status.setBusy(i, false)
+	assert.True(t, status.Healthy())
+}
@@ -5,11 +5,11 @@ import (
 	"net/url"
 	"path"
 	"strings"
-	"time"
 
 	"github.com/ChristopherHX/github-act-runner/protocol"
 	"github.com/ChristopherHX/github-act-runner/protocol/logger"
 	"github.com/ChristopherHX/github-act-runner/protocol/run"
+	"github.com/ChristopherHX/github-act-runner/runnerconfiguration"
 )
 
 type WorkerContext interface {
@@ -18,6 +18,12 @@ type WorkerContext interface {
 	Message() *protocol.AgentJobRequestMessage
 	Logger() *logger.JobLogger
 	JobExecCtx() context.Context
+	// WorkDir returns the directory reserved for this job, an empty string means the current directory
+	WorkDir() string
+	// Profile returns the execution profile of the runner instance, nil if it has none
+	Profile() *runnerconfiguration.ExecutionProfile
+	// Workspace returns the directories of the job below the work folder, nil if the worker uses the current directory
+	Workspace() *Workspace
 }
 
 type DefaultWorkerContext struct {
@@ -26,9 +32,20 @@ type DefaultWorkerContext struct {
 	JobExecutionContext context.Context
 	VssConnection       *protocol.VssConnection
 	RunnerLogger        BasicLogger
+	WorkDirectory       string
+	ExecutionProfile    *runnerconfiguration.ExecutionProfile
+	JobWorkspace        *Workspace
+	// OnFinishJob is called with the result of the job, before it is reported to the actions service
+	OnFinishJob func(result string)
 }
 
 func (wc *DefaultWorkerContext) FinishJob(result string, outputs *map[string]protocol.VariableValue) {
+	if wc.OnFinishJob != nil {
+		wc.OnFinishJob(result)
+	}
+	// Losing the result would leave the job running until it times out
+	con := *wc.VssConnection
+	con.RetryPolicy = protocol.PersistentRetryPolicy
 	if strings.EqualFold(wc.Message().MessageType, "RunnerJobRequest") {
 		payload := &run.CompleteJobRequest{
 			PlanID:     wc.Message().Plan.PlanID,
@@ -42,35 +59,29 @@ func (wc *DefaultWorkerContext) FinishJob(result string, outputs *map[string]pro
 		recs := wc.Logger().TimelineRecords
 		if recs != nil {
 			stepResults := []run.StepResult{}
+			// The annotations of the job include the ones of all steps
+			annotations := []run.Annotation{}
 			for i, rec := range recs.Value {
-				if i == 0 {
-					annotations := make([]run.Annotation, len(rec.Issues))
-					for i, issue := range rec.Issues {
-						annotations[i] = run.IssueToAnnotation(issue)
-					}
-					payload.Annotations = annotations
-				} else if rec != nil {
+				if rec == nil {
+					continue
+				}
+				for _, issue := range rec.Issues {
+					annotations = append(annotations, run.IssueToAnnotation(issue))
+				}
+				if i > 0 {
 					stepResults = append(stepResults, run.TimeLineRecordToStepResult(*rec))
 				}
 			}
+			payload.Annotations = annotations
 			payload.StepResults = stepResults
 		}
 
 		completejobUrl, _ := url.Parse(wc.VssConnection.TenantURL)
 		completejobUrl.Path = path.Join(completejobUrl.Path, "completejob")
-		for i := 0; ; i++ {
-			if err := wc.VssConnection.RequestWithContext2(context.Background(), "POST", completejobUrl.String(), "", payload, nil); err != nil {
-				wc.RunnerLogger.Printf("Failed to finish Job '%v' with Status %v: %v\n", wc.Message().JobDisplayName, result, err.Error())
-			} else {
-				wc.RunnerLogger.Printf("Finished Job '%v' with Status %v\n", wc.Message().JobDisplayName, result)
-				break
-			}
-			if i < 10 {
-				wc.RunnerLogger.Printf("Retry finishing '%v' in 10 seconds attempt %v of 10\n", wc.Message().JobDisplayName, i+1)
-				<-time.After(time.Second * 10)
-			} else {
-				break
-			}
+		if err := con.RequestWithContext2(context.Background(), "POST", completejobUrl.String(), "", payload, nil); err != nil {
+			wc.RunnerLogger.Printf("Failed to finish Job '%v' with Status %v: %v\n", wc.Message().JobDisplayName, result, err.Error())
+		} else {
+			wc.RunnerLogger.Printf("Finished Job '%v' with Status %v\n", wc.Message().JobDisplayName, result)
 		}
 		return
 	}
@@ -81,19 +92,10 @@ func (wc *DefaultWorkerContext) FinishJob(result string, outputs *map[string]pro
 		Result:    result,
 		Outputs:   outputs,
 	}
-	for i := 0; ; i++ {
-		if err := wc.VssConnection.FinishJob(finish, wc.Message().Plan); err != nil {
-			wc.RunnerLogger.Printf("Failed to finish Job '%v' with Status %v: %v\n", wc.Message().JobDisplayName, result, err.Error())
-		} else {
-			wc.RunnerLogger.Printf("Finished Job '%v' with Status %v\n", wc.Message().JobDisplayName, result)
-			break
-		}
-		if i < 10 {
-			wc.RunnerLogger.Printf("Retry finishing '%v' in 10 seconds attempt %v of 10\n", wc.Message().JobDisplayName, i+1)
-			<-time.After(time.Second * 10)
-		} else {
-			break
-		}
+	if err := con.FinishJob(finish, wc.Message().Plan); err != nil {
+		wc.RunnerLogger.Printf("Failed to finish Job '%v' with Status %v: %v\n", wc.Message().JobDisplayName, result, err.Error())
+	} else {
+		wc.RunnerLogger.Printf("Finished Job '%v' with Status %v\n", wc.Message().JobDisplayName, result)
 	}
 }
 
@@ -113,12 +115,18 @@ func (wc *DefaultWorkerContext) FailInitJob(title string, message string) {
 		}
 	}
 	wc.Logger().Log(message)
-	e.Complete("Failed")
-	wc.Logger().Logger.Close()
-	wc.Logger().MoveNext()
-	wc.Logger().TimelineRecords.Value[0].Complete("Failed")
-	wc.Logger().Finish()
-	wc.FinishJob("Failed", &map[string]protocol.VariableValue{})
+	completeJob(wc, "Failed", "Failed")
+}
+
+// completeJob completes the current timeline record with stepResult and finishes the job with result
+func completeJob(wc WorkerContext, stepResult string, result string) {
+	jlogger := wc.Logger()
+	jlogger.Current().Complete(stepResult)
+	jlogger.Logger.Close()
+	jlogger.MoveNext()
+	jlogger.TimelineRecords.Value[0].Complete(result)
+	jlogger.Finish()
+	wc.FinishJob(result, &map[string]protocol.VariableValue{})
 }
 
 func (wc *DefaultWorkerContext) Message() *protocol.AgentJobRequestMessage {
@@ -133,6 +141,18 @@ func (wc *DefaultWorkerContext) JobExecCtx() context.Context {
 	return wc.JobExecutionContext
 }
 
+func (wc *DefaultWorkerContext) WorkDir() string {
+	return wc.WorkDirectory
+}
+
+func (wc *DefaultWorkerContext) Profile() *runnerconfiguration.ExecutionProfile {
+	return wc.ExecutionProfile
+}
+
+func (wc *DefaultWorkerContext) Workspace() *Workspace {
+	return wc.JobWorkspace
+}
+
 func (wc *DefaultWorkerContext) Init() {
 	jobVssConnection, vssConnectionData, err := wc.Message().GetConnection("SystemVssConnection")
 	if err != nil {
@@ -143,6 +163,7 @@ func (wc *DefaultWorkerContext) Init() {
 	if wc.VssConnection != nil {
 		jobVssConnection.Client = wc.VssConnection.Client
 		jobVssConnection.Trace = wc.VssConnection.Trace
+		jobVssConnection.Logger = wc.VssConnection.Logger
 	}
 	wc.VssConnection = jobVssConnection
 
@@ -2,13 +2,14 @@ package actionsrunner
 
 import (
 	"encoding/binary"
-	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 
+	"github.com/ChristopherHX/github-act-runner/common"
 	"github.com/ChristopherHX/github-act-runner/protocol"
 )
 
@@ -17,23 +18,15 @@ type WorkerRunnerEnvironment struct {
 }
 
 func (arunner *WorkerRunnerEnvironment) WriteJson(path string, value interface{}) error {
-	b, err := json.MarshalIndent(value, "", "    ")
-	if err != nil {
-		return err
-	}
-	return ioutil.WriteFile(path, b, 0777)
+	return common.WriteJson(path, value)
 }
 
 func (arunner *WorkerRunnerEnvironment) ReadJson(path string, value interface{}) error {
-	cont, err := ioutil.ReadFile(path)
-	if err != nil {
-		return err
-	}
-	return json.Unmarshal(cont, value)
+	return common.ReadJson(path, value)
 }
 
 func (arunner *WorkerRunnerEnvironment) Remove(fname string) error {
-	return os.Remove(fname)
+	return common.RemoveJson(fname)
 }
 
 func (arunner *WorkerRunnerEnvironment) Printf(format string, a ...interface{}) {
@@ -41,12 +34,40 @@ func (arunner *WorkerRunnerEnvironment) Printf(format string, a ...interface{})
 }
 
 func (arunner *WorkerRunnerEnvironment) ExecWorker(run *RunRunner, wc WorkerContext, jobreq *protocol.AgentJobRequestMessage, src []byte) error {
+	args, err := wc.Profile().WorkerCommand(arunner.WorkerArgs)
+	if err != nil {
+		return err
+	}
+	if len(args) <= 0 {
+		return fmt.Errorf("missing WorkerArgs to execute an external worker")
+	}
+	return ExecExternalWorker(args, wc.WorkDir(), wc, src)
+}
+
+// ExecExternalWorker passes the job to the worker process of args, dir is its working directory or empty to use the current one
+func ExecExternalWorker(args []string, dir string, wc WorkerContext, src []byte) error {
 	jlogger := wc.Logger()
 	jobExecCtx := wc.JobExecCtx()
-	if len(arunner.WorkerArgs) <= 0 {
-		return fmt.Errorf("missing WorkerArgs to execute an external worker")
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0777); err != nil {
+			return err
+		}
+		var err error
+		if dir, err = filepath.Abs(dir); err != nil {
+			return err
+		}
+		// A relative worker path is relative to the current directory, not to the one of the worker
+		if !filepath.IsAbs(args[0]) && strings.ContainsAny(args[0], "/\\") {
+			worker, err := filepath.Abs(args[0])
+			if err != nil {
+				return err
+			}
+			args = append([]string{worker}, args[1:]...)
+		}
 	}
-	worker := exec.Command(arunner.WorkerArgs[0], arunner.WorkerArgs[1:]...)
+	worker := exec.Command(args[0], args[1:]...)
+	worker.Env = wc.Profile().Environ()
+	worker.Dir = dir
 	in, err := worker.StdinPipe()
 	if err != nil {
 		return err
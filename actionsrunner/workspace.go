@@ -0,0 +1,127 @@
+package actionsrunner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ChristopherHX/github-act-runner/protocol"
+	"github.com/ChristopherHX/github-act-runner/runnerconfiguration"
+)
+
+// Values of WorkspaceOptions.Clean
+const (
+	WorkspaceCleanOutputs   = "outputs"
+	WorkspaceCleanResources = "resources"
+	WorkspaceCleanAll       = "all"
+)
+
+// Workspace is the directory layout of a job below the work folder, it matches the one of actions/runner
+type Workspace struct {
+	// WorkFolder is the root of all workspaces of the runner instance
+	WorkFolder string
+	// RunnerWorkspace is <work>/<repo>, the value of runner.workspace
+	RunnerWorkspace string
+	// Workspace is <work>/<repo>/<repo>, the value of github.workspace
+	Workspace string
+}
+
+// instanceWorkFolder returns the work folder of the runner instance, instances without one get their own directory below _work
+func instanceWorkFolder(instance *runnerconfiguration.RunnerInstance) string {
+	if instance.Profile != nil && instance.Profile.WorkFolder != "" {
+		return instance.Profile.WorkFolder
+	}
+	if instance.WorkFolder != "" {
+		return instance.WorkFolder
+	}
+	if instance.Agent == nil || instance.Agent.Name == "" {
+		return "_work"
+	}
+	return filepath.Join("_work", strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' {
+			return '_'
+		}
+		return r
+	}, instance.Agent.Name))
+}
+
+// PrepareWorkspace creates the workspace of the job below workFolder, files of previous jobs are kept
+func PrepareWorkspace(workFolder string, jobreq *protocol.AgentJobRequestMessage) (*Workspace, error) {
+	workFolder, err := filepath.Abs(workFolder)
+	if err != nil {
+		return nil, err
+	}
+	name := repositoryName(jobreq)
+	ws := &Workspace{
+		WorkFolder:      workFolder,
+		RunnerWorkspace: filepath.Join(workFolder, name),
+		Workspace:       filepath.Join(workFolder, name, name),
+	}
+	if err := ws.create(); err != nil {
+		return nil, err
+	}
+	return ws, nil
+}
+
+// Clean removes the files of previous jobs as requested by the WorkspaceOptions of the job request, options may be nil
+func (ws *Workspace) Clean(options *protocol.WorkspaceOptions) error {
+	if options == nil || options.Clean == nil {
+		return nil
+	}
+	var dir string
+	switch strings.ToLower(*options.Clean) {
+	case WorkspaceCleanAll:
+		dir = ws.RunnerWorkspace
+	case WorkspaceCleanResources:
+		// The repository checkout
+		dir = ws.Workspace
+	case WorkspaceCleanOutputs:
+		// Files written by steps next to the checkout
+		return ws.cleanOutputs()
+	case "":
+		return nil
+	default:
+		return fmt.Errorf("unknown workspace clean option %v", *options.Clean)
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to remove %v: %w", dir, err)
+	}
+	return ws.create()
+}
+
+// cleanOutputs removes everything in the runner workspace except for the checkout
+func (ws *Workspace) cleanOutputs() error {
+	entries, err := os.ReadDir(ws.RunnerWorkspace)
+	if err != nil {
+		return fmt.Errorf("failed to read %v: %w", ws.RunnerWorkspace, err)
+	}
+	for _, entry := range entries {
+		file := filepath.Join(ws.RunnerWorkspace, entry.Name())
+		if file == ws.Workspace {
+			continue
+		}
+		if err := os.RemoveAll(file); err != nil {
+			return fmt.Errorf("failed to remove %v: %w", file, err)
+		}
+	}
+	return nil
+}
+
+func (ws *Workspace) create() error {
+	return os.MkdirAll(ws.Workspace, 0777)
+}
+
+// repositoryName returns the name of github.repository, which names the workspace
+func repositoryName(jobreq *protocol.AgentJobRequestMessage) string {
+	if github, ok := jobreq.ContextData["github"]; ok {
+		if githubMap, ok := github.ToRawObject().(map[string]interface{}); ok {
+			if repository, ok := githubMap["repository"].(string); ok && repository != "" {
+				if name := filepath.Base(filepath.FromSlash(repository)); name != "." && name != ".." && name != string(filepath.Separator) {
+					return name
+				}
+			}
+		}
+	}
+	return "workspace"
+}
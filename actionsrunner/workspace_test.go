@@ -0,0 +1,99 @@
+package actionsrunner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ChristopherHX/github-act-runner/protocol"
+	"github.com/ChristopherHX/github-act-runner/runnerconfiguration"
+	"github.com/stretchr/testify/assert"
+)
+
+func newWorkspaceJob(t *testing.T, repository string) *protocol.AgentJobRequestMessage {
+	github, err := protocol.ToPipelineContextDataWithError(map[string]interface{}{"repository": repository})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &protocol.AgentJobRequestMessage{ContextData: map[string]protocol.PipelineContextData{"github": github}}
+}
+
+func TestPrepareWorkspace(t *testing.T) {
+	workFolder := t.TempDir()
+	ws, err := PrepareWorkspace(workFolder, newWorkspaceJob(t, "octo-org/hello-world"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, filepath.Join(workFolder, "hello-world"), ws.RunnerWorkspace)
+	assert.Equal(t, filepath.Join(workFolder, "hello-world", "hello-world"), ws.Workspace)
+	assert.DirExists(t, ws.Workspace)
+	checkout := filepath.Join(ws.Workspace, "README.md")
+	assert.NoError(t, ioutil.WriteFile(checkout, []byte("content"), 0600))
+
+	_, err = PrepareWorkspace(workFolder, newWorkspaceJob(t, "octo-org/hello-world"))
+	assert.NoError(t, err)
+	assert.FileExists(t, checkout, "the checkout is kept without workspace options")
+}
+
+func TestWorkspaceClean(t *testing.T) {
+	ws, err := PrepareWorkspace(t.TempDir(), newWorkspaceJob(t, "octo-org/hello-world"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	checkout := filepath.Join(ws.Workspace, "README.md")
+	output := filepath.Join(ws.RunnerWorkspace, "output.txt")
+	for _, file := range []string{checkout, output} {
+		assert.NoError(t, ioutil.WriteFile(file, []byte("content"), 0600))
+	}
+	clean := func(option string) error {
+		return ws.Clean(&protocol.WorkspaceOptions{Clean: &option})
+	}
+	assert.NoError(t, ws.Clean(nil))
+	assert.NoError(t, clean(""))
+	assert.FileExists(t, output)
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(ws.RunnerWorkspace, "bin"), 0777))
+	assert.NoError(t, clean(WorkspaceCleanOutputs))
+	assert.NoFileExists(t, output)
+	assert.NoDirExists(t, filepath.Join(ws.RunnerWorkspace, "bin"))
+	assert.FileExists(t, checkout)
+
+	assert.NoError(t, ioutil.WriteFile(output, []byte("content"), 0600))
+	assert.NoError(t, clean("Resources"))
+	assert.NoFileExists(t, checkout)
+	assert.FileExists(t, output)
+
+	assert.NoError(t, clean(WorkspaceCleanAll))
+	assert.NoFileExists(t, output)
+	assert.DirExists(t, ws.Workspace)
+
+	assert.Error(t, clean("everything"))
+}
+
+func TestPrepareWorkspaceWithoutRepository(t *testing.T) {
+	workFolder := t.TempDir()
+	ws, err := PrepareWorkspace(workFolder, newWorkspaceJob(t, ""))
+	if assert.NoError(t, err) {
+		assert.Equal(t, filepath.Join(workFolder, "workspace", "workspace"), ws.Workspace)
+		assert.DirExists(t, ws.Workspace)
+	}
+}
+
+func TestInstanceWorkFolder(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		instance *runnerconfiguration.RunnerInstance
+		expected string
+	}{
+		{"default", &runnerconfiguration.RunnerInstance{Agent: &protocol.TaskAgent{Name: "runner-1"}}, filepath.Join("_work", "runner-1")},
+		{"name with separators", &runnerconfiguration.RunnerInstance{Agent: &protocol.TaskAgent{Name: "org/runner:1"}}, filepath.Join("_work", "org_runner_1")},
+		{"without agent", &runnerconfiguration.RunnerInstance{}, "_work"},
+		{"instance", &runnerconfiguration.RunnerInstance{Agent: &protocol.TaskAgent{Name: "runner-1"}, WorkFolder: "work"}, "work"},
+		{"profile", &runnerconfiguration.RunnerInstance{WorkFolder: "work", Profile: &runnerconfiguration.ExecutionProfile{WorkFolder: "profile"}}, "profile"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, instanceWorkFolder(tc.instance))
+		})
+	}
+}
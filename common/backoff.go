@@ -0,0 +1,50 @@
+package common
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var (
+	jitterMu sync.Mutex
+	jitter   = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// Backoff computes exponentially growing delays between retries, randomized to spread the retries of many runners
+type Backoff struct {
+	Min     time.Duration
+	Max     time.Duration
+	attempt int
+}
+
+// Next returns the delay before the next retry, between half and the full exponential delay
+func (b *Backoff) Next() time.Duration {
+	d := b.Max
+	if b.attempt < 62 {
+		if exp := b.Min << b.attempt; exp > 0 && exp < b.Max {
+			d = exp
+			b.attempt++
+		}
+	}
+	half := d / 2
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+	return half + time.Duration(jitter.Int63n(int64(d-half)+1))
+}
+
+// Reset starts again with Min after a success
+func (b *Backoff) Reset() {
+	b.attempt = 0
+}
+
+// Wait sleeps for the next delay, it returns early with the error of the context
+func (b *Backoff) Wait(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(b.Next()):
+		return nil
+	}
+}
@@ -0,0 +1,19 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff(t *testing.T) {
+	b := &Backoff{Min: time.Second, Max: 10 * time.Second}
+	for _, max := range []time.Duration{1, 2, 4, 8, 10, 10} {
+		d := b.Next()
+		assert.GreaterOrEqual(t, d, max*time.Second/2)
+		assert.LessOrEqual(t, d, max*time.Second)
+	}
+	b.Reset()
+	assert.LessOrEqual(t, b.Next(), time.Second)
+}
@@ -0,0 +1,138 @@
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// HttpOptions configures the transport of every outbound connection of the runner
+type HttpOptions struct {
+	// CABundle is a PEM file with certificates trusted in addition to the system roots, e.g. of an internal CA
+	CABundle string
+	// ClientCert and ClientKey are PEM files of the client certificate used for mTLS
+	ClientCert string
+	ClientKey  string
+	// ProxyUsername and ProxyPassword authenticate at the proxy of HTTP_PROXY or HTTPS_PROXY, unless the proxy url has credentials
+	ProxyUsername         string
+	ProxyPassword         string
+	SkipTLSCertValidation bool
+	// Proxy overrides HTTP_PROXY, HTTPS_PROXY and NO_PROXY of the environment
+	Proxy *httpproxy.Config
+}
+
+// HttpOptionsFromEnvironment reads ACTIONS_RUNNER_CA_BUNDLE, ACTIONS_RUNNER_CLIENT_CERT, ACTIONS_RUNNER_CLIENT_KEY,
+// ACTIONS_RUNNER_PROXY_USERNAME, ACTIONS_RUNNER_PROXY_PASSWORD and SKIP_TLS_CERT_VALIDATION
+func HttpOptionsFromEnvironment() *HttpOptions {
+	skip, _ := LookupEnvBool("SKIP_TLS_CERT_VALIDATION")
+	return &HttpOptions{
+		CABundle:              os.Getenv("ACTIONS_RUNNER_CA_BUNDLE"),
+		ClientCert:            os.Getenv("ACTIONS_RUNNER_CLIENT_CERT"),
+		ClientKey:             os.Getenv("ACTIONS_RUNNER_CLIENT_KEY"),
+		ProxyUsername:         os.Getenv("ACTIONS_RUNNER_PROXY_USERNAME"),
+		ProxyPassword:         os.Getenv("ACTIONS_RUNNER_PROXY_PASSWORD"),
+		SkipTLSCertValidation: skip,
+	}
+}
+
+func (o *HttpOptions) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: o.SkipTLSCertValidation}
+	if o.CABundle != "" {
+		pem, err := ioutil.ReadFile(o.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the ca bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in the ca bundle %v", o.CABundle)
+		}
+		config.RootCAs = pool
+	}
+	if o.ClientCert != "" || o.ClientKey != "" {
+		if o.ClientCert == "" || o.ClientKey == "" {
+			return nil, fmt.Errorf("a client certificate requires both ACTIONS_RUNNER_CLIENT_CERT and ACTIONS_RUNNER_CLIENT_KEY")
+		}
+		cert, err := tls.LoadX509KeyPair(o.ClientCert, o.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+func (o *HttpOptions) proxy() func(*http.Request) (*url.URL, error) {
+	config := o.Proxy
+	if config == nil {
+		config = httpproxy.FromEnvironment()
+	}
+	proxyFunc := config.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		proxyURL, err := proxyFunc(req.URL)
+		if err != nil || proxyURL == nil {
+			return proxyURL, err
+		}
+		if proxyURL.User == nil && o.ProxyUsername != "" {
+			// Don't modify the cached url of proxyFunc
+			withUser := *proxyURL
+			withUser.User = url.UserPassword(o.ProxyUsername, o.ProxyPassword)
+			return &withUser, nil
+		}
+		return proxyURL, nil
+	}
+}
+
+// NewTransport creates a transport, which honors the proxy settings, trusted certificates and client certificate of o
+func (o *HttpOptions) NewTransport() (*http.Transport, error) {
+	tlsConfig, err := o.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = 1
+	transport.IdleConnTimeout = 100 * time.Second
+	transport.TLSClientConfig = tlsConfig
+	transport.Proxy = o.proxy()
+	return transport, nil
+}
+
+// NewClient creates a client using the transport of NewTransport.
+// If the options are invalid, the returned client fails every request with the returned error
+func (o *HttpOptions) NewClient() (*http.Client, error) {
+	client := &http.Client{
+		Timeout: 100 * time.Second,
+	}
+	transport, err := o.NewTransport()
+	if err != nil {
+		client.Transport = &failingTransport{err: err}
+		return client, err
+	}
+	client.Transport = transport
+	return client, nil
+}
+
+// NewHttpClient creates the client for outbound connections configured by the environment, see HttpOptionsFromEnvironment
+func NewHttpClient() (*http.Client, error) {
+	return HttpOptionsFromEnvironment().NewClient()
+}
+
+type failingTransport struct {
+	err error
+}
+
+func (t *failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	return nil, t.err
+}
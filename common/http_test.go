@@ -0,0 +1,70 @@
+package common
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http/httpproxy"
+)
+
+func TestHttpOptionsCABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	client, err := (&HttpOptions{}).NewClient()
+	assert.NoError(t, err)
+	_, err = client.Get(server.URL)
+	assert.Error(t, err)
+
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	assert.NoError(t, ioutil.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+	client, err = (&HttpOptions{CABundle: bundle}).NewClient()
+	assert.NoError(t, err)
+	resp, err := client.Get(server.URL)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	}
+
+	client, err = (&HttpOptions{CABundle: filepath.Join(t.TempDir(), "missing.pem")}).NewClient()
+	assert.Error(t, err)
+	_, rerr := client.Get(server.URL)
+	assert.ErrorIs(t, rerr, err)
+
+	_, err = (&HttpOptions{ClientCert: bundle}).NewClient()
+	assert.Error(t, err)
+}
+
+func TestHttpOptionsProxy(t *testing.T) {
+	transport, err := (&HttpOptions{
+		ProxyUsername: "user",
+		ProxyPassword: "p@ss",
+		Proxy: &httpproxy.Config{
+			HTTPSProxy: "http://proxy.example.com:3128",
+			NoProxy:    ".internal.example.com",
+		},
+	}).NewTransport()
+	assert.NoError(t, err)
+
+	req, _ := http.NewRequest("GET", "https://ghes.example.com/_apis/connectionData", nil)
+	proxyURL, err := transport.Proxy(req)
+	assert.NoError(t, err)
+	if assert.NotNil(t, proxyURL) {
+		assert.Equal(t, "proxy.example.com:3128", proxyURL.Host)
+		password, _ := proxyURL.User.Password()
+		assert.Equal(t, "user", proxyURL.User.Username())
+		assert.Equal(t, "p@ss", password)
+	}
+
+	req, _ = http.NewRequest("GET", "https://actions.internal.example.com/", nil)
+	proxyURL, err = transport.Proxy(req)
+	assert.NoError(t, err)
+	assert.Nil(t, proxyURL)
+}
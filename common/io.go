@@ -2,21 +2,93 @@ package common
 
 import (
 	"encoding/json"
+	"errors"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
 )
 
+// BackupSuffix is appended to the path of a state file to get its last-known-good copy
+const BackupSuffix = ".bak"
+
+// WriteFileAtomic replaces path with data, readers either see the old or the new content even if the process crashes
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(path))
+	return nil
+}
+
+// syncDir persists a rename, not every platform allows to fsync a directory
+func syncDir(dir string) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+}
+
+// WriteJson atomically saves value to path with permissions for the current user only,
+// the previous content is kept as backup if it was valid json
 func WriteJson(path string, value interface{}) error {
 	b, err := json.MarshalIndent(value, "", "    ")
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(path, b, 0777)
+	if old, err := ioutil.ReadFile(path); err == nil && json.Valid(old) {
+		if err := WriteFileAtomic(path+BackupSuffix, old, 0600); err != nil {
+			return err
+		}
+	}
+	return WriteFileAtomic(path, b, 0600)
 }
 
+// ReadJson loads value from path, if path is unreadable or corrupted the backup is restored
 func ReadJson(path string, value interface{}) error {
 	cont, err := ioutil.ReadFile(path)
-	if err != nil {
+	if err == nil {
+		if err = json.Unmarshal(cont, value); err == nil {
+			return nil
+		}
+	} else if errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	backup, berr := ioutil.ReadFile(path + BackupSuffix)
+	if berr != nil || json.Unmarshal(backup, value) != nil {
+		return err
+	}
+	_ = WriteFileAtomic(path, backup, 0600)
+	return nil
+}
+
+// RemoveJson deletes path together with its backup
+func RemoveJson(path string) error {
+	if err := os.Remove(path + BackupSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
 		return err
 	}
-	return json.Unmarshal(cont, value)
+	return os.Remove(path)
 }
@@ -0,0 +1,40 @@
+package common
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteJsonRecovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "settings.json")
+	assert.NoError(t, WriteJson(path, map[string]int{"version": 1}))
+	assert.NoError(t, WriteJson(path, map[string]int{"version": 2}))
+	if info, err := os.Stat(path); assert.NoError(t, err) && filepath.Separator == '/' {
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+
+	// Simulate a crash of an older runner in the middle of writing the file
+	assert.NoError(t, ioutil.WriteFile(path, []byte(`{"vers`), 0600))
+	value := map[string]int{}
+	assert.NoError(t, ReadJson(path, &value))
+	assert.Equal(t, 1, value["version"], "the last-known-good backup is loaded")
+	value = map[string]int{}
+	assert.NoError(t, ReadJson(path, &value), "the backup has been restored")
+	assert.Equal(t, 1, value["version"])
+
+	// A corrupted file never replaces the backup
+	assert.NoError(t, ioutil.WriteFile(path, []byte(`{"vers`), 0600))
+	assert.NoError(t, WriteJson(path, map[string]int{"version": 3}))
+	assert.NoError(t, ReadJson(path+BackupSuffix, &value))
+	assert.Equal(t, 1, value["version"])
+
+	assert.NoError(t, RemoveJson(path))
+	assert.ErrorIs(t, ReadJson(path, &value), os.ErrNotExist)
+	entries, err := ioutil.ReadDir(filepath.Dir(path))
+	assert.NoError(t, err)
+	assert.Empty(t, entries, "no temporary files or backups are left behind")
+}
@@ -0,0 +1,79 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Supported formats of NewLogger
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// Fields of runner-level log entries
+const (
+	LogFieldRunner    = "runner"
+	LogFieldURL       = "url"
+	LogFieldJobID     = "job_id"
+	LogFieldRequestID = "request_id"
+	LogFieldSessionID = "session_id"
+)
+
+// NewLogger creates a logger, which writes entries of at least the given level to out in text or json format
+func NewLogger(out io.Writer, format string, level string) (*logrus.Logger, error) {
+	logger := logrus.New()
+	logger.SetOutput(out)
+	switch strings.ToLower(format) {
+	case "", LogFormatText:
+		logger.SetFormatter(&TextFormatter{})
+	case LogFormatJSON:
+		logger.SetFormatter(&jsonFormatter{})
+	default:
+		return nil, fmt.Errorf("unknown log format %v, expected %v or %v", format, LogFormatText, LogFormatJSON)
+	}
+	if level != "" {
+		lvl, err := logrus.ParseLevel(level)
+		if err != nil {
+			return nil, err
+		}
+		logger.SetLevel(lvl)
+	}
+	return logger, nil
+}
+
+// TextFormatter writes the message of an entry prefixed by the runner and its registration url,
+// all other fields are omitted
+type TextFormatter struct {
+}
+
+func (f *TextFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if runner, ok := entry.Data[LogFieldRunner]; ok {
+		fmt.Fprintf(buf, "%v ( %v ): ", runner, entry.Data[LogFieldURL])
+	}
+	switch entry.Level {
+	case logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel:
+		buf.WriteString("Error: ")
+	case logrus.WarnLevel:
+		buf.WriteString("Warning: ")
+	}
+	buf.WriteString(strings.TrimSuffix(entry.Message, "\n"))
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+type jsonFormatter struct {
+	logrus.JSONFormatter
+}
+
+func (f *jsonFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	// Messages of Printf style calls usually end with a newline
+	e := *entry
+	e.Message = strings.TrimSuffix(entry.Message, "\n")
+	return f.JSONFormatter.Format(&e)
+}
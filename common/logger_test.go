@@ -0,0 +1,40 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger, err := NewLogger(buf, LogFormatText, "")
+	assert.NoError(t, err)
+	entry := logger.WithFields(logrus.Fields{
+		LogFieldRunner: "runner",
+		LogFieldURL:    "https://github.com/owner/repo",
+		LogFieldJobID:  "job",
+	})
+	entry.Printf("Running Job '%v'\n", "build")
+	entry.Warnf("Failed to renew job")
+	entry.Debugf("not enabled")
+	assert.Equal(t, "runner ( https://github.com/owner/repo ): Running Job 'build'\nrunner ( https://github.com/owner/repo ): Warning: Failed to renew job\n", buf.String())
+
+	buf.Reset()
+	logger, err = NewLogger(buf, LogFormatJSON, "debug")
+	assert.NoError(t, err)
+	logger.WithField(LogFieldJobID, "job").Debugf("Hello World\n")
+	line := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, "Hello World", line["msg"])
+	assert.Equal(t, "debug", line["level"])
+	assert.Equal(t, "job", line[LogFieldJobID])
+
+	_, err = NewLogger(buf, "xml", "")
+	assert.Error(t, err)
+	_, err = NewLogger(buf, LogFormatJSON, "verbose")
+	assert.Error(t, err)
+}
@@ -9,6 +9,7 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"os/signal"
 	"runtime"
@@ -17,22 +18,29 @@ import (
 	"github.com/ChristopherHX/github-act-runner/actionsdotnetactcompat"
 	"github.com/ChristopherHX/github-act-runner/actionsrunner"
 	"github.com/ChristopherHX/github-act-runner/common"
+	"github.com/ChristopherHX/github-act-runner/metrics"
 	"github.com/ChristopherHX/github-act-runner/protocol"
 	"github.com/ChristopherHX/github-act-runner/runnerconfiguration"
 	runnerCompat "github.com/ChristopherHX/github-act-runner/runnerconfiguration/compat"
 	"github.com/joho/godotenv"
 	"github.com/kardianos/service"
 	"github.com/nektos/act/pkg/container"
+	"github.com/sirupsen/logrus"
 
 	"github.com/spf13/cobra"
 )
 
 type RunRunner struct {
-	Once       bool
-	Terminal   bool
-	Trace      bool
-	WorkerArgs []string
-	JITConfig  string
+	Once        bool
+	Terminal    bool
+	Trace       bool
+	WorkerArgs  []string
+	JITConfig   string
+	MaxParallel int
+	MetricsAddr string
+	HealthAddr  string
+	LogFormat   string
+	LogLevel    string
 }
 
 type JobRun struct {
@@ -134,21 +142,61 @@ func (run *RunRunner) Run() int {
 
 func (run *RunRunner) RunWithContext(listenerctx context.Context, ctx context.Context) int {
 	var settings *runnerconfiguration.RunnerSettings
-	var err error
+	logger, err := common.NewLogger(os.Stdout, run.LogFormat, run.LogLevel)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err.Error())
+		return 1
+	}
+	if run.Trace && !logger.IsLevelEnabled(logrus.DebugLevel) {
+		logger.SetLevel(logrus.DebugLevel)
+	}
+	status := &actionsrunner.Status{}
+	muxes := map[string]*http.ServeMux{}
+	getMux := func(addr string) *http.ServeMux {
+		if mux, ok := muxes[addr]; ok {
+			return mux
+		}
+		mux := http.NewServeMux()
+		muxes[addr] = mux
+		return mux
+	}
+	if run.MetricsAddr != "" {
+		getMux(run.MetricsAddr).Handle("/metrics", metrics.DefaultRegistry)
+	}
+	if run.HealthAddr != "" {
+		mux := getMux(run.HealthAddr)
+		mux.Handle("/healthz", status.HealthHandler())
+		mux.Handle("/readyz", status.ReadyHandler())
+	}
+	for addr, mux := range muxes {
+		server := &http.Server{
+			Addr:    addr,
+			Handler: mux,
+		}
+		go func() {
+			if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Errorf("Failed to listen on %v: %v", server.Addr, err.Error())
+			}
+		}()
+		defer server.Close()
+	}
 	if run.JITConfig != "" {
 		if settings, err = runnerCompat.ParseJitRunnerConfig(run.JITConfig); err != nil {
-			fmt.Printf("jitconfig is corrupted: %v, please reconfigure the runner\n", err.Error())
+			logger.Errorf("jitconfig is corrupted: %v, please reconfigure the runner", err.Error())
 			return 1
 		}
 	} else if settings, err = loadConfiguration(); err != nil {
-		fmt.Printf("settings.json is corrupted: %v, please reconfigure the runner\n", err.Error())
+		logger.Errorf("settings.json is corrupted: %v, please reconfigure the runner", err.Error())
 		return 1
 	}
 	runner := &actionsrunner.RunRunner{
-		Once:     run.Once,
-		Trace:    run.Trace,
-		Version:  version,
-		Settings: settings,
+		Once:        run.Once,
+		Trace:       run.Trace,
+		Version:     version,
+		MaxParallel: run.MaxParallel,
+		Status:      status,
+		Logger:      logger,
+		Settings:    settings,
 	}
 	err = runner.Run(&actionsdotnetactcompat.ActRunner{
 		WorkerRunnerEnvironment: actionsrunner.WorkerRunnerEnvironment{
@@ -156,7 +204,7 @@ func (run *RunRunner) RunWithContext(listenerctx context.Context, ctx context.Co
 		},
 	}, listenerctx, ctx)
 	if err != nil {
-		fmt.Printf("Error: %v\n", err.Error())
+		logger.Errorf("%v", err.Error())
 		return 1
 	}
 	return 0
@@ -183,7 +231,12 @@ type RunRunnerSvc struct {
 }
 
 func (svc *RunRunnerSvc) Start(s service.Service) error {
-	runner := &RunRunner{}
+	runner := &RunRunner{
+		MetricsAddr: os.Getenv("ACTIONS_RUNNER_METRICS_ADDR"),
+		HealthAddr:  os.Getenv("ACTIONS_RUNNER_HEALTH_ADDR"),
+		LogFormat:   os.Getenv("ACTIONS_RUNNER_LOG_FORMAT"),
+		LogLevel:    os.Getenv("ACTIONS_RUNNER_LOG_LEVEL"),
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	listenerctx, cancelListener := context.WithCancel(context.Background())
@@ -229,6 +282,10 @@ func main() {
 			settings := &runnerconfiguration.RunnerSettings{}
 			if !printJITConfig {
 				settings, _ = loadConfiguration()
+			} else if config.KeyProvider != "" {
+				// The jitconfig contains the private key, a key stored by the key provider would never be used or deleted
+				fmt.Fprintf(os.Stderr, "ignoring the %v key provider, the jitconfig contains the private key\n", config.KeyProvider)
+				config.KeyProvider = ""
 			}
 			settings, err := config.Configure(settings, &interactive{}, nil)
 			if printJITConfig {
@@ -244,8 +301,8 @@ func main() {
 				}
 			} else {
 				if settings != nil {
-					os.Remove("agent.json")
-					os.Remove("auth.json")
+					common.RemoveJson("agent.json")
+					common.RemoveJson("auth.json")
 					os.Remove("cred.pkcs1")
 					if saveActionsRunnerConfig && len(settings.Instances) == 1 {
 						runnerCompat.FromRunnerInstance(settings.Instances[0], runnerCompat.DefaultConfigFileAccess{})
@@ -280,7 +337,8 @@ func main() {
 	cmdConfigure.Flags().BoolVar(&config.DisableUpdate, "disableupdate", false, "actions/runner disable updates (has no effect)")
 	cmdConfigure.Flags().BoolVar(&printJITConfig, "print-jitconfig", false, "print the runner configuration as jitconfig")
 	cmdConfigure.Flags().BoolVar(&saveActionsRunnerConfig, "save-actionsrunnerconfig", false, "use the format of actions/runner to save the configuration")
-	cmdConfigure.Flags().StringVar(&config.WorkFolder, "work", "_work", "actions/runner work folder (has no effect)")
+	cmdConfigure.Flags().StringVar(&config.WorkFolder, "work", "", "actions/runner work folder, the base directory of the job workspaces, _work/<runner name> if empty")
+	cmdConfigure.Flags().StringVar(&config.KeyProvider, "key-provider", "", "store the private key of the runner with file, keyring or command instead of settings.json, ignored with --print-jitconfig")
 
 	var cmdRun = &cobra.Command{
 		Use:   "run",
@@ -295,6 +353,11 @@ func main() {
 	cmdRun.Flags().BoolVarP(&run.Terminal, "terminal", "t", true, "allocate a pty if possible")
 	cmdRun.Flags().BoolVar(&run.Trace, "trace", false, "trace http communication with the github action service")
 	cmdRun.Flags().StringSliceVar(&run.WorkerArgs, "worker-args", []string{}, "custom worker for your runner")
+	cmdRun.Flags().IntVar(&run.MaxParallel, "max-parallel", 1, "maximum number of jobs executed at the same time by all configured runners")
+	cmdRun.Flags().StringVar(&run.MetricsAddr, "metrics-addr", os.Getenv("ACTIONS_RUNNER_METRICS_ADDR"), "serve prometheus metrics on /metrics of this address, e.g. :9100")
+	cmdRun.Flags().StringVar(&run.HealthAddr, "health-addr", os.Getenv("ACTIONS_RUNNER_HEALTH_ADDR"), "serve /healthz and /readyz on this address, e.g. :8080")
+	cmdRun.Flags().StringVar(&run.LogFormat, "log-format", os.Getenv("ACTIONS_RUNNER_LOG_FORMAT"), "format of the runner output, text or json")
+	cmdRun.Flags().StringVar(&run.LogLevel, "log-level", os.Getenv("ACTIONS_RUNNER_LOG_LEVEL"), "minimum level of the runner output, e.g. debug, info, warning or error")
 	cmdRun.Flags().StringVarP(&run.JITConfig, "jitconfig", "", os.Getenv("ACTIONS_RUNNER_INPUT_JITCONFIG"), "read the runner configuration from the jitconfig")
 	var jitConfig string
 	local, _ := common.LookupEnvBool("ACTIONS_RUNNER_INPUT_LOCAL")
@@ -319,17 +382,17 @@ func main() {
 				settings, err = remove.Remove(settings, &interactive{}, nil)
 			}
 			if (settings != nil || local) && jitConfig == "" {
-				os.Remove("agent.json")
-				os.Remove("auth.json")
+				common.RemoveJson("agent.json")
+				common.RemoveJson("auth.json")
 				os.Remove("cred.pkcs1")
-				os.Remove(".runner")
-				os.Remove(".credentials")
-				os.Remove(".credentials_rsaparams")
+				common.RemoveJson(".runner")
+				common.RemoveJson(".credentials")
+				common.RemoveJson(".credentials_rsaparams")
 
 				if !local && len(settings.Instances) > 0 {
 					common.WriteJson("settings.json", settings)
 				} else {
-					os.Remove("settings.json")
+					common.RemoveJson("settings.json")
 				}
 			}
 			if err != nil {
@@ -352,6 +415,8 @@ func main() {
 	cmdRemove.Flags().StringVarP(&jitConfig, "jitconfig", "", os.Getenv("ACTIONS_RUNNER_INPUT_JITCONFIG"), "read the runner configuration from the jitconfig")
 	cmdRemove.Flags().BoolVar(&local, "local", local, "only delete the configuration")
 
+	var workerWorkspace actionsrunner.Workspace
+	var workerProfileEnv []string
 	var cmdWorker = &cobra.Command{
 		Use:   "worker",
 		Short: "Run as self-hosted runner worker, can be used to create ephemeral worker without exposing other job requests",
@@ -381,6 +446,18 @@ func main() {
 								JobExecutionContext: execcontext,
 								RunnerLogger:        &actionsrunner.ConsoleLogger{},
 							}
+							if workerWorkspace.Workspace != "" {
+								wc.JobWorkspace = &workerWorkspace
+								wc.WorkDirectory = workerWorkspace.Workspace
+							}
+							if len(workerProfileEnv) > 0 {
+								// The runner passes the env of the profile through the environment of the worker
+								profile := &runnerconfiguration.ExecutionProfile{Env: map[string]string{}}
+								for _, k := range workerProfileEnv {
+									profile.Env[k] = os.Getenv(k)
+								}
+								wc.ExecutionProfile = profile
+							}
 							wc.Init()
 							wc.Logger().Append(protocol.CreateTimelineEntry(jobreq.JobID, "__setup", "Set up Job")).Start()
 							wc.Logger().MoveNext()
@@ -394,6 +471,10 @@ func main() {
 			<-ccontext.Done()
 		},
 	}
+	cmdWorker.Flags().StringVar(&workerWorkspace.WorkFolder, "work-folder", "", "work folder of the runner instance")
+	cmdWorker.Flags().StringVar(&workerWorkspace.RunnerWorkspace, "runner-workspace", "", "runner.workspace of the job")
+	cmdWorker.Flags().StringVar(&workerWorkspace.Workspace, "workspace", "", "github.workspace of the job, the worker runs the job in the current directory if empty")
+	cmdWorker.Flags().StringArrayVar(&workerProfileEnv, "profile-env", []string{}, "name of an environment variable of the execution profile")
 	var cmdSvc = &cobra.Command{
 		Use:   "svc",
 		Short: "Manage the runner as a system service",
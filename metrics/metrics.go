@@ -0,0 +1,244 @@
+// Package metrics implements counters, gauges and histograms in the prometheus text exposition format
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultRegistry contains all metrics of the runner process
+var DefaultRegistry = &Registry{}
+
+// DefaultBuckets are used by histograms of durations in seconds
+var DefaultBuckets = []float64{10, 30, 60, 120, 300, 600, 1800, 3600, 7200, 21600}
+
+type Registry struct {
+	mu       sync.Mutex
+	families []*family
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+	counts      []uint64
+	count       uint64
+}
+
+type family struct {
+	name    string
+	help    string
+	typ     string
+	labels  []string
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*series
+}
+
+func (r *Registry) register(name string, help string, typ string, labels []string, buckets []float64) *family {
+	f := &family{
+		name:    name,
+		help:    help,
+		typ:     typ,
+		labels:  labels,
+		buckets: buckets,
+		series:  map[string]*series{},
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.families {
+		if existing.name == name {
+			panic(fmt.Sprintf("metric %v is already registered", name))
+		}
+	}
+	r.families = append(r.families, f)
+	return f
+}
+
+func (f *family) with(labelValues []string, update func(s *series)) {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metric %v expects %v label values, got %v", f.name, len(f.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string{}, labelValues...)}
+		if f.buckets != nil {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	update(s)
+}
+
+// CounterVec is a monotonically increasing value partitioned by labels
+type CounterVec struct {
+	f *family
+}
+
+func (r *Registry) NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	return &CounterVec{f: r.register(name, help, "counter", labels, nil)}
+}
+
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	return DefaultRegistry.NewCounterVec(name, help, labels...)
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic("counters cannot decrease")
+	}
+	c.f.with(labelValues, func(s *series) {
+		s.value += v
+	})
+}
+
+// GaugeVec is a value, which can go up and down, partitioned by labels
+type GaugeVec struct {
+	f *family
+}
+
+func (r *Registry) NewGaugeVec(name string, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{f: r.register(name, help, "gauge", labels, nil)}
+}
+
+func NewGaugeVec(name string, help string, labels ...string) *GaugeVec {
+	return DefaultRegistry.NewGaugeVec(name, help, labels...)
+}
+
+func (g *GaugeVec) Set(v float64, labelValues ...string) {
+	g.f.with(labelValues, func(s *series) {
+		s.value = v
+	})
+}
+
+func (g *GaugeVec) Add(v float64, labelValues ...string) {
+	g.f.with(labelValues, func(s *series) {
+		s.value += v
+	})
+}
+
+// HistogramVec counts observations in cumulative buckets partitioned by labels
+type HistogramVec struct {
+	f *family
+}
+
+func (r *Registry) NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	buckets = append([]float64{}, buckets...)
+	sort.Float64s(buckets)
+	return &HistogramVec{f: r.register(name, help, "histogram", labels, buckets)}
+}
+
+func NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	return DefaultRegistry.NewHistogramVec(name, help, buckets, labels...)
+}
+
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	h.f.with(labelValues, func(s *series) {
+		for i, upper := range h.f.buckets {
+			if v <= upper {
+				s.counts[i]++
+			}
+		}
+		s.count++
+		s.value += v
+	})
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func writeLabels(buf *bytes.Buffer, names []string, values []string, extraName string, extraValue string) {
+	if len(names) == 0 && extraName == "" {
+		return
+	}
+	buf.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(buf, "%v=\"%v\"", name, labelValueEscaper.Replace(values[i]))
+	}
+	if extraName != "" {
+		if len(names) > 0 {
+			buf.WriteByte(',')
+		}
+		fmt.Fprintf(buf, "%v=\"%v\"", extraName, extraValue)
+	}
+	buf.WriteByte('}')
+}
+
+func (f *family) write(buf *bytes.Buffer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fmt.Fprintf(buf, "# HELP %v %v\n# TYPE %v %v\n", f.name, helpEscaper.Replace(f.help), f.name, f.typ)
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := f.series[key]
+		if f.typ != "histogram" {
+			buf.WriteString(f.name)
+			writeLabels(buf, f.labels, s.labelValues, "", "")
+			fmt.Fprintf(buf, " %v\n", formatFloat(s.value))
+			continue
+		}
+		for i, upper := range f.buckets {
+			buf.WriteString(f.name + "_bucket")
+			writeLabels(buf, f.labels, s.labelValues, "le", formatFloat(upper))
+			fmt.Fprintf(buf, " %v\n", s.counts[i])
+		}
+		buf.WriteString(f.name + "_bucket")
+		writeLabels(buf, f.labels, s.labelValues, "le", "+Inf")
+		fmt.Fprintf(buf, " %v\n", s.count)
+		buf.WriteString(f.name + "_sum")
+		writeLabels(buf, f.labels, s.labelValues, "", "")
+		fmt.Fprintf(buf, " %v\n", formatFloat(s.value))
+		buf.WriteString(f.name + "_count")
+		writeLabels(buf, f.labels, s.labelValues, "", "")
+		fmt.Fprintf(buf, " %v\n", s.count)
+	}
+}
+
+// WriteTo writes all metrics in the prometheus text exposition format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := append([]*family{}, r.families...)
+	r.mu.Unlock()
+	buf := &bytes.Buffer{}
+	for _, f := range families {
+		f.write(buf)
+	}
+	return buf.WriteTo(w)
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = r.WriteTo(w)
+}
@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	r := &Registry{}
+	counter := r.NewCounterVec("test_polls_total", "Number of polls", "runner", "kind")
+	gauge := r.NewGaugeVec("test_queue_depth", "Queue depth")
+	histogram := r.NewHistogramVec("test_duration_seconds", "Duration", []float64{10, 1}, "result")
+
+	counter.Inc("a", "EOF")
+	counter.Add(2, "a", "EOF")
+	counter.Inc("b\"", "AccessDeniedException")
+	gauge.Add(3)
+	gauge.Add(-1)
+	histogram.Observe(0.5, "Succeeded")
+	histogram.Observe(5, "Succeeded")
+
+	buf := &bytes.Buffer{}
+	_, err := r.WriteTo(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, `# HELP test_polls_total Number of polls
+# TYPE test_polls_total counter
+test_polls_total{runner="a",kind="EOF"} 3
+test_polls_total{runner="b\"",kind="AccessDeniedException"} 1
+# HELP test_queue_depth Queue depth
+# TYPE test_queue_depth gauge
+test_queue_depth 2
+# HELP test_duration_seconds Duration
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{result="Succeeded",le="1"} 1
+test_duration_seconds_bucket{result="Succeeded",le="10"} 2
+test_duration_seconds_bucket{result="Succeeded",le="+Inf"} 2
+test_duration_seconds_sum{result="Succeeded"} 5.5
+test_duration_seconds_count{result="Succeeded"} 2
+`, buf.String())
+}
@@ -4,13 +4,14 @@ import (
 	"bytes"
 	"context"
 	"crypto/rsa"
-	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"os"
 	"path"
 	"regexp"
 	"strings"
@@ -18,17 +19,47 @@ import (
 
 	"github.com/ChristopherHX/github-act-runner/common"
 	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
 )
 
+// defaultLogger is used by connections without a Logger, it writes plain messages to stdout
+var defaultLogger = &logrus.Logger{
+	Out:       os.Stdout,
+	Formatter: &common.TextFormatter{},
+	Hooks:     make(logrus.LevelHooks),
+	Level:     logrus.DebugLevel,
+}
+
 type VssConnection struct {
-	Client         *http.Client
-	TenantURL      string
-	connectionData *ConnectionData
-	Token          string
-	PoolID         int64
-	TaskAgent      *TaskAgent
-	Key            *rsa.PrivateKey
-	Trace          bool
+	Client    *http.Client
+	TenantURL string
+	Token     string
+	PoolID    int64
+	TaskAgent *TaskAgent
+	Key       *rsa.PrivateKey
+	Trace     bool
+	// Logger receives trace output and retry messages, copies of the connection share it
+	Logger logrus.FieldLogger
+	// RetryPolicy of all requests, DefaultRetryPolicy if nil
+	RetryPolicy *RetryPolicy
+	// TokenSource authenticates the requests instead of Token, it is created on demand from TaskAgent and Key.
+	// Set it before copying the connection to share the token between the copies
+	TokenSource *TokenSource
+}
+
+// GetLogger returns the Logger of the connection or a logger writing to stdout
+func (vssConnection *VssConnection) GetLogger() logrus.FieldLogger {
+	if vssConnection.Logger == nil {
+		return defaultLogger
+	}
+	return vssConnection.Logger
+}
+
+func (vssConnection *VssConnection) getRetryPolicy() *RetryPolicy {
+	if vssConnection.RetryPolicy == nil {
+		return DefaultRetryPolicy
+	}
+	return vssConnection.RetryPolicy
 }
 
 func (vssConnection *VssConnection) BuildURL(relativePath string, ppath map[string]string, query map[string]string) (string, error) {
@@ -57,66 +88,52 @@ func (vssConnection *VssConnection) BuildURL(relativePath string, ppath map[stri
 
 func (vssConnection *VssConnection) HttpClient() *http.Client {
 	if vssConnection.Client == nil {
-		customTransport := http.DefaultTransport.(*http.Transport).Clone()
-		customTransport.MaxIdleConns = 1
-		customTransport.IdleConnTimeout = 100 * time.Second
-		if v, ok := common.LookupEnvBool("SKIP_TLS_CERT_VALIDATION"); ok && v {
-			customTransport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		client, err := common.NewHttpClient()
+		if err != nil {
+			vssConnection.GetLogger().Errorf("Invalid http configuration: %v", err)
 		}
-		vssConnection.Client = &http.Client{
-			Timeout:   100 * time.Second,
-			Transport: customTransport,
-			CheckRedirect: func(req *http.Request, via []*http.Request) error {
-				if len(via) >= 10 {
-					return fmt.Errorf("stopped after 10 redirects")
-				}
-				return nil
-			},
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return fmt.Errorf("stopped after 10 redirects")
+			}
+			return nil
 		}
+		vssConnection.Client = client
 	}
 	return vssConnection.Client
 }
 
-func (vssConnection *VssConnection) authorize() (*VssOAuthTokenResponse, error) {
-	var authResponse *VssOAuthTokenResponse
-	var err error
-	authResponse, err = vssConnection.TaskAgent.Authorize(vssConnection.HttpClient(), vssConnection.Key)
-	if err == nil {
-		return authResponse, nil
+func (vssConnection *VssConnection) getTokenSource() *TokenSource {
+	if vssConnection.TokenSource == nil && vssConnection.TaskAgent != nil && vssConnection.Key != nil {
+		vssConnection.TokenSource = NewTokenSource(vssConnection.TaskAgent, vssConnection.Key, vssConnection.HttpClient())
 	}
-	return nil, err
+	return vssConnection.TokenSource
+}
+
+// AccessToken returns the token of the TokenSource or Token if the connection cannot request one
+func (vssConnection *VssConnection) AccessToken(ctx context.Context) (string, error) {
+	if ts := vssConnection.getTokenSource(); ts != nil {
+		return ts.Token(ctx)
+	}
+	return vssConnection.Token, nil
 }
 
 func (vssConnection *VssConnection) Request(serviceID string, protocol string, method string, urlParameter map[string]string, queryParameter map[string]string, requestBody interface{}, responseBody interface{}) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	// Every attempt may take up to a minute
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute+vssConnection.getRetryPolicy().Budget)
 	defer cancel()
 	return vssConnection.RequestWithContext(ctx, serviceID, protocol, method, urlParameter, queryParameter, requestBody, responseBody)
 }
 
 func (vssConnection *VssConnection) GetServiceURL(ctx context.Context, serviceID string, urlParameter map[string]string, queryParameter map[string]string) (string, error) {
-	if vssConnection.connectionData == nil {
-		for i := 1; ; {
-			vssConnection.connectionData = vssConnection.GetConnectionData()
-			if vssConnection.connectionData != nil {
-				break
-			}
-			maxtime := 60 * 10
-			var dtime time.Duration = time.Duration(i) * time.Second
-			if i < maxtime {
-				i *= 2
-			} else {
-				dtime = time.Duration(maxtime) * time.Second
-			}
-			fmt.Printf("Retry retrieving connectiondata from the server in %v seconds\n", dtime)
-			select {
-			case <-ctx.Done():
-				return "", fmt.Errorf("aborted to get connectionData")
-			case <-time.After(dtime):
-			}
-		}
-	}
+	return vssConnection.getServiceURL(ctx, serviceID, urlParameter, queryParameter, false)
+}
 
-	serv := vssConnection.connectionData.GetServiceDefinition(serviceID)
+func (vssConnection *VssConnection) getServiceURL(ctx context.Context, serviceID string, urlParameter map[string]string, queryParameter map[string]string, refresh bool) (string, error) {
+	serv, err := vssConnection.getServiceDefinition(ctx, serviceID, refresh)
+	if err != nil {
+		return "", err
+	}
 	if urlParameter == nil {
 		urlParameter = map[string]string{}
 	}
@@ -133,7 +150,24 @@ func (vssConnection *VssConnection) RequestWithContext(ctx context.Context, serv
 	if err != nil {
 		return err
 	}
-	return vssConnection.RequestWithContext2(ctx, method, url, protocol, requestBody, responseBody)
+	body := requestBody
+	if buf, ok := requestBody.(*bytes.Buffer); ok {
+		// Keep the content for a second request
+		content := buf.Bytes()
+		body = bytes.NewBuffer(content)
+		requestBody = bytes.NewBuffer(content)
+	}
+	err = vssConnection.RequestWithContext2(ctx, method, url, protocol, body, responseBody)
+	var serr *ServiceError
+	if errors.As(err, &serr) && serr.StatusCode == http.StatusNotFound && serr.TypeKey == "" {
+		// Without an exception of the actions service the route doesn't exist, e.g. it moved after an upgrade of GitHub Enterprise Server
+		newURL, uerr := vssConnection.getServiceURL(ctx, serviceID, urlParameter, queryParameter, true)
+		if uerr == nil && newURL != url {
+			vssConnection.GetLogger().Infof("The url of service %v changed from %v to %v", serviceID, url, newURL)
+			return vssConnection.RequestWithContext2(ctx, method, newURL, protocol, requestBody, responseBody)
+		}
+	}
+	return err
 }
 
 func extractReader(body interface{}) (io.Reader, []string, error) {
@@ -185,7 +219,7 @@ func setResponseBody(r io.Reader, body interface{}) error {
 	return nil
 }
 
-func (vssConnection *VssConnection) requestWithContextNoAuth(ctx context.Context, method string, requesturl string, apiversion string, requestBody interface{}, responseBody interface{}) (int, error) {
+func (vssConnection *VssConnection) requestWithContextNoAuth(ctx context.Context, method string, requesturl string, apiversion string, token string, requestBody interface{}, responseBody interface{}) (int, error) {
 	buf, reqContentType, err := extractReader(requestBody)
 	if err != nil {
 		return 0, err
@@ -228,11 +262,11 @@ func (vssConnection *VssConnection) requestWithContextNoAuth(ctx context.Context
 		header["X-TFS-FedAuthRedirect"] = []string{"Suppress"}
 		header["X-TFS-Session"] = []string{uuid.NewString()}
 	}
-	if len(vssConnection.Token) > 0 {
-		header["Authorization"] = []string{"bearer " + vssConnection.Token}
+	if len(token) > 0 {
+		header["Authorization"] = []string{"bearer " + token}
 	}
 	if vssConnection.Trace {
-		fmt.Printf("Http %v Request started %v\nHeaders:\n%v\nBody: `%v`\n", method, requesturl, getHeadersAsString(request.Header), getBodyAsString(buf))
+		vssConnection.GetLogger().Debugf("Http %v Request started %v\nHeaders:\n%v\nBody: `%v`", method, requesturl, getHeadersAsString(request.Header), getBodyAsString(buf))
 	}
 
 	response, err := vssConnection.HttpClient().Do(request)
@@ -247,7 +281,7 @@ func (vssConnection *VssConnection) requestWithContextNoAuth(ctx context.Context
 	var responseReader io.Reader
 	failed := response.StatusCode < 200 || response.StatusCode >= 300
 	readResponse := vssConnection.Trace || failed
-	if responseBody != nil {
+	if responseBody != nil || failed {
 		responseReader = response.Body
 		if readResponse {
 			rbytes, err = ioutil.ReadAll(response.Body)
@@ -259,10 +293,10 @@ func (vssConnection *VssConnection) requestWithContextNoAuth(ctx context.Context
 	}
 	traceMessage := fmt.Sprintf("Http %v Request finished %v %v\nHeaders: \n%v\nBody: `%v`\n", method, response.StatusCode, requesturl, getHeadersAsString(response.Header), string(rbytes))
 	if vssConnection.Trace {
-		fmt.Print(traceMessage)
+		vssConnection.GetLogger().Debug(traceMessage)
 	}
 	if failed {
-		return response.StatusCode, fmt.Errorf("http failure: %v", traceMessage)
+		return response.StatusCode, newServiceError(response, rbytes, "http failure: "+traceMessage)
 	}
 	if response.StatusCode != 200 && responseBody != nil {
 		return response.StatusCode, io.EOF
@@ -270,17 +304,77 @@ func (vssConnection *VssConnection) requestWithContextNoAuth(ctx context.Context
 	return response.StatusCode, setResponseBody(responseReader, responseBody)
 }
 
+// RequestWithContext2 sends the request, failed attempts are retried according to the RetryPolicy of the connection or of WithRetryPolicy.
+// A *bytes.Buffer or io.Reader requestBody is sent as is, the content is buffered to send it again,
+// any other requestBody is encoded as json for every attempt
 func (vssConnection *VssConnection) RequestWithContext2(ctx context.Context, method string, url string, protocol string, requestBody interface{}, responseBody interface{}) error {
-	statusCode, err := vssConnection.requestWithContextNoAuth(ctx, method, url, protocol, requestBody, responseBody)
-	if (statusCode == 401 || statusCode == 400) && vssConnection.TaskAgent != nil && vssConnection.Key != nil {
-		authResponse, err := vssConnection.authorize()
-		if err != nil {
+	body := func() interface{} {
+		return requestBody
+	}
+	if r, ok := requestBody.(io.Reader); ok {
+		// Every attempt consumes the reader
+		var content []byte
+		if buf, ok := r.(*bytes.Buffer); ok {
+			content = buf.Bytes()
+		} else {
+			var err error
+			if content, err = io.ReadAll(r); err != nil {
+				return err
+			}
+		}
+		body = func() interface{} {
+			return bytes.NewBuffer(content)
+		}
+	}
+	policy := vssConnection.getRetryPolicy()
+	if p, ok := ctx.Value(retryPolicyKey{}).(*RetryPolicy); ok && p != nil {
+		policy = p
+	}
+	var deadline time.Time
+	if policy.Budget > 0 {
+		deadline = time.Now().Add(policy.Budget)
+	}
+	if ctxDeadline, ok := ctx.Deadline(); ok && (deadline.IsZero() || ctxDeadline.Before(deadline)) {
+		deadline = ctxDeadline
+	}
+	backoff := common.Backoff{Min: policy.MinBackoff, Max: policy.MaxBackoff}
+	for attempt := 1; ; attempt++ {
+		err := vssConnection.requestWithAuth(ctx, method, url, protocol, body, responseBody)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		delay, retry := policy.delay(attempt, method, err, backoff.Next(), deadline)
+		if !retry {
 			return err
 		}
-		vssConnection.Token = authResponse.AccessToken
-		_, err = vssConnection.requestWithContextNoAuth(ctx, method, url, protocol, requestBody, responseBody)
+		vssConnection.GetLogger().Warnf("Http %v %v failed, retry in %v attempt %v of %v: %v", method, url, delay.Round(time.Millisecond), attempt, policy.MaxAttempts-1, err.Error())
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (vssConnection *VssConnection) requestWithAuth(ctx context.Context, method string, url string, protocol string, requestBody func() interface{}, responseBody interface{}) error {
+	ts := vssConnection.getTokenSource()
+	if ts == nil {
+		_, err := vssConnection.requestWithContextNoAuth(ctx, method, url, protocol, vssConnection.Token, requestBody(), responseBody)
+		return err
+	}
+	token, err := ts.Token(ctx)
+	if err != nil {
 		return err
 	}
+	statusCode, err := vssConnection.requestWithContextNoAuth(ctx, method, url, protocol, token, requestBody(), responseBody)
+	if statusCode == 401 || statusCode == 400 {
+		// The token has been revoked before it expired
+		ts.Invalidate(token)
+		if token, err = ts.Token(ctx); err != nil {
+			return err
+		}
+		_, err = vssConnection.requestWithContextNoAuth(ctx, method, url, protocol, token, requestBody(), responseBody)
+	}
 	return err
 }
 
@@ -358,6 +452,28 @@ func (vssConnection *VssConnection) UploadLogFile(timelineID string, jobreq *Age
 	return log.ID, err
 }
 
+// CreateAttachment uploads content as an attachment of the timeline record
+func (vssConnection *VssConnection) CreateAttachment(ctx context.Context, timelineID string, jobreq *AgentJobRequestMessage, recordID string, attachmentType string, name string, content io.Reader) (*TaskAttachment, error) {
+	buf := &bytes.Buffer{}
+	if _, err := buf.ReadFrom(content); err != nil {
+		return nil, err
+	}
+	attachment := &TaskAttachment{}
+	err := vssConnection.RequestWithContext(ctx, "7898f959-9cdf-4096-b29e-7f293031629e", "5.1-preview", "PUT", map[string]string{
+		"scopeIdentifier": jobreq.Plan.ScopeIdentifier,
+		"planId":          jobreq.Plan.PlanID,
+		"hubName":         jobreq.Plan.PlanType,
+		"timelineId":      timelineID,
+		"recordId":        recordID,
+		"type":            attachmentType,
+		"name":            name,
+	}, map[string]string{}, buf, attachment)
+	if err != nil {
+		return nil, err
+	}
+	return attachment, nil
+}
+
 func (vssConnection *VssConnection) DeleteAgent(taskAgent *TaskAgent) error {
 	return vssConnection.Request("e298ef32-5878-4cab-993c-043836571f42", "6.0-preview.2", "DELETE", map[string]string{
 		"poolId":  fmt.Sprint(vssConnection.PoolID),
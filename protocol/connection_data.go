@@ -2,8 +2,31 @@ package protocol
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/url"
 	"path"
+	"sync"
+	"time"
+
+	"github.com/ChristopherHX/github-act-runner/common"
+)
+
+// ErrUnknownService is returned if the actions service doesn't provide a service id even after refreshing its connectionData
+var ErrUnknownService = errors.New("unknown service")
+
+// ConnectionDataTTL is the time the connectionData of a tenant is cached, before it is fetched again
+var ConnectionDataTTL = time.Hour
+
+type cachedConnectionData struct {
+	data    *ConnectionData
+	fetched time.Time
+}
+
+// connectionDataCache is shared by all connections to the same TenantURL
+var (
+	connectionDataMu    sync.Mutex
+	connectionDataCache = map[string]*cachedConnectionData{}
 )
 
 type ServiceDefinition struct {
@@ -27,10 +50,10 @@ type ConnectionData struct {
 	LocationServiceData LocationServiceData
 }
 
-func (vssConnection *VssConnection) GetConnectionData() *ConnectionData {
+func (vssConnection *VssConnection) GetConnectionData(ctx context.Context) (*ConnectionData, error) {
 	url, err := url.Parse(vssConnection.TenantURL)
 	if err != nil {
-		return nil
+		return nil, err
 	}
 	url.Path = path.Join(url.Path, "_apis/connectionData")
 	q := url.Query()
@@ -39,11 +62,64 @@ func (vssConnection *VssConnection) GetConnectionData() *ConnectionData {
 	q.Add("lastChangeId64", "-1")
 	url.RawQuery = q.Encode()
 	connectionData := &ConnectionData{}
-	err = vssConnection.RequestWithContext2(context.Background(), "GET", url.String(), "1.0", nil, connectionData)
+	err = vssConnection.RequestWithContext2(ctx, "GET", url.String(), "1.0", nil, connectionData)
 	if err != nil {
-		return nil
+		return nil, err
+	}
+	return connectionData, nil
+}
+
+// loadConnectionData returns the cached connectionData of the tenant, it is fetched again if it is expired or refresh is set.
+// fetched reports whether the returned connectionData is not from the cache
+func (vssConnection *VssConnection) loadConnectionData(ctx context.Context, refresh bool) (connectionData *ConnectionData, fetched bool, err error) {
+	connectionDataMu.Lock()
+	cached, ok := connectionDataCache[vssConnection.TenantURL]
+	connectionDataMu.Unlock()
+	if ok && !refresh && time.Since(cached.fetched) < ConnectionDataTTL {
+		return cached.data, false, nil
+	}
+	backoff := common.Backoff{Min: time.Second, Max: 10 * time.Minute}
+	for {
+		connectionData, err = vssConnection.GetConnectionData(ctx)
+		if err == nil {
+			break
+		}
+		if ok && !refresh {
+			// Keep using the expired connectionData while the server is unavailable
+			vssConnection.GetLogger().Warnf("Failed to refresh connectiondata, using the cached one: %v", err.Error())
+			return cached.data, false, nil
+		}
+		delay := backoff.Next()
+		vssConnection.GetLogger().Infof("Retry retrieving connectiondata from the server in %v: %v", delay.Round(time.Second), err.Error())
+		select {
+		case <-ctx.Done():
+			return nil, false, fmt.Errorf("aborted to get connectionData: %w", err)
+		case <-time.After(delay):
+		}
+	}
+	connectionDataMu.Lock()
+	connectionDataCache[vssConnection.TenantURL] = &cachedConnectionData{data: connectionData, fetched: time.Now()}
+	connectionDataMu.Unlock()
+	return connectionData, true, nil
+}
+
+// getServiceDefinition looks up the service id, the connectionData is refreshed once if it doesn't know the service
+func (vssConnection *VssConnection) getServiceDefinition(ctx context.Context, serviceID string, refresh bool) (*ServiceDefinition, error) {
+	connectionData, fetched, err := vssConnection.loadConnectionData(ctx, refresh)
+	if err != nil {
+		return nil, err
+	}
+	serv := connectionData.GetServiceDefinition(serviceID)
+	if serv == nil && !fetched {
+		if connectionData, _, err = vssConnection.loadConnectionData(ctx, true); err != nil {
+			return nil, err
+		}
+		serv = connectionData.GetServiceDefinition(serviceID)
+	}
+	if serv == nil {
+		return nil, fmt.Errorf("%w %v, it is not provided by %v", ErrUnknownService, serviceID, vssConnection.TenantURL)
 	}
-	return connectionData
+	return serv, nil
 }
 
 func (connectionData *ConnectionData) GetServiceDefinition(id string) *ServiceDefinition {
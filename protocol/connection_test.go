@@ -0,0 +1,296 @@
+package protocol_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ChristopherHX/github-act-runner/common"
+	"github.com/ChristopherHX/github-act-runner/protocol"
+	"github.com/ChristopherHX/github-act-runner/protocol/fakeservice"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionMessages(t *testing.T) {
+	server := fakeservice.NewServer()
+	defer server.Close()
+	instance, err := server.AddRunner("runner")
+	assert.NoError(t, err)
+
+	vssConnection := &protocol.VssConnection{
+		TenantURL: instance.Auth.TenantURL,
+		PoolID:    instance.PoolID,
+		TaskAgent: instance.Agent,
+		Key:       instance.PKey,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	session, err := vssConnection.CreateSession(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, server.Sessions())
+
+	jobreq := server.NewJob("build")
+	assert.NoError(t, server.QueueJob("runner", jobreq))
+	message, err := session.GetNextMessage(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "PipelineAgentJobRequest", message.MessageType)
+	src, err := message.Decrypt(session.Block)
+	assert.NoError(t, err)
+	received := &protocol.AgentJobRequestMessage{}
+	assert.NoError(t, json.Unmarshal(src, received))
+	assert.Equal(t, jobreq.JobID, received.JobID)
+	assert.Equal(t, "build", received.JobDisplayName)
+	assert.NoError(t, session.DeleteMessage(ctx, message))
+
+	assert.NoError(t, session.Delete(ctx))
+	assert.Equal(t, 0, server.Sessions())
+}
+
+func TestMessageListenerRedelivery(t *testing.T) {
+	server := fakeservice.NewServer()
+	defer server.Close()
+	instance, err := server.AddRunner("runner")
+	assert.NoError(t, err)
+
+	vssConnection := &protocol.VssConnection{
+		TenantURL: instance.Auth.TenantURL,
+		PoolID:    instance.PoolID,
+		TaskAgent: instance.Agent,
+		Key:       instance.PKey,
+		// The failed delete must reach the listener
+		RetryPolicy: protocol.NoRetryPolicy,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	session, err := vssConnection.CreateSession(ctx)
+	assert.NoError(t, err)
+	listener := session.Listener()
+
+	job1 := server.NewJob("build1")
+	job2 := server.NewJob("build2")
+	assert.NoError(t, server.QueueJob("runner", job1))
+	assert.NoError(t, server.QueueJob("runner", job2))
+	server.FailMessageDeletes(1)
+
+	message, err := listener.Poll(ctx)
+	assert.NoError(t, err)
+	assert.Error(t, listener.Acknowledge(ctx, message))
+	first := message.MessageID
+
+	// The first message is delivered again, but the listener skips it
+	message, err = listener.GetNextMessage(ctx)
+	assert.NoError(t, err)
+	assert.Greater(t, message.MessageID, first)
+	assert.NoError(t, listener.Acknowledge(ctx, message))
+	assert.Equal(t, message.MessageID, listener.LastMessageID())
+
+	_, err = listener.Poll(ctx)
+	assert.ErrorIs(t, err, io.EOF, "both messages have been deleted")
+}
+
+func TestPollIsRetriedByListener(t *testing.T) {
+	server := fakeservice.NewServer()
+	defer server.Close()
+	instance, err := server.AddRunner("runner")
+	assert.NoError(t, err)
+
+	vssConnection := &protocol.VssConnection{
+		TenantURL:   instance.Auth.TenantURL,
+		PoolID:      instance.PoolID,
+		TaskAgent:   instance.Agent,
+		Key:         instance.PKey,
+		RetryPolicy: &protocol.RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	session, err := vssConnection.CreateSession(ctx)
+	assert.NoError(t, err)
+	listener := session.Listener()
+	listener.Backoff = common.Backoff{Min: time.Millisecond, Max: time.Millisecond}
+
+	assert.NoError(t, server.QueueJob("runner", server.NewJob("build")))
+	server.FailMessagePolls(1)
+	_, err = listener.Poll(ctx)
+	assert.Error(t, err, "the connection must not retry the poll")
+
+	server.FailMessagePolls(2)
+	message, err := listener.GetNextMessage(ctx)
+	assert.NoError(t, err)
+	assert.NotNil(t, message)
+}
+
+func TestRetryFailedDelete(t *testing.T) {
+	server := fakeservice.NewServer()
+	defer server.Close()
+	instance, err := server.AddRunner("runner")
+	assert.NoError(t, err)
+
+	vssConnection := &protocol.VssConnection{
+		TenantURL:   instance.Auth.TenantURL,
+		PoolID:      instance.PoolID,
+		TaskAgent:   instance.Agent,
+		Key:         instance.PKey,
+		RetryPolicy: &protocol.RetryPolicy{MaxAttempts: 3, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	session, err := vssConnection.CreateSession(ctx)
+	assert.NoError(t, err)
+
+	assert.NoError(t, server.QueueJob("runner", server.NewJob("build1")))
+	assert.NoError(t, server.QueueJob("runner", server.NewJob("build2")))
+	message, err := session.GetNextMessage(ctx)
+	assert.NoError(t, err)
+	server.FailMessageDeletes(2)
+	assert.NoError(t, session.DeleteMessage(ctx, message))
+
+	message, err = session.GetNextMessage(ctx)
+	assert.NoError(t, err)
+	server.FailMessageDeletes(3)
+	assert.Error(t, session.DeleteMessage(ctx, message), "all attempts failed")
+}
+
+func TestConnectionDataCache(t *testing.T) {
+	server := fakeservice.NewServer()
+	defer server.Close()
+	instance, err := server.AddRunner("runner")
+	assert.NoError(t, err)
+
+	vssConnection := &protocol.VssConnection{
+		TenantURL: instance.Auth.TenantURL,
+		PoolID:    instance.PoolID,
+		TaskAgent: instance.Agent,
+		Key:       instance.PKey,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	server.HideService("fc825784-c92a-4299-9221-998a02d1b54f", true)
+	session, err := vssConnection.CreateSession(ctx)
+	assert.NoError(t, err)
+	copy := *vssConnection
+	_, err = copy.GetServiceURL(ctx, "c3a054f6-7a8a-49c0-944e-3a8e5d7adfd7", nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, server.ConnectionDataRequests(), "copies share the connectionData")
+
+	// An unknown service refreshes the connectionData once, instead of crashing the runner
+	_, err = vssConnection.GetServiceURL(ctx, "fc825784-c92a-4299-9221-998a02d1b54f", nil, nil)
+	assert.ErrorIs(t, err, protocol.ErrUnknownService)
+	assert.Equal(t, 2, server.ConnectionDataRequests())
+
+	server.HideService("fc825784-c92a-4299-9221-998a02d1b54f", false)
+	_, err = vssConnection.GetServiceURL(ctx, "fc825784-c92a-4299-9221-998a02d1b54f", nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, server.ConnectionDataRequests())
+	_, err = copy.GetServiceURL(ctx, "fc825784-c92a-4299-9221-998a02d1b54f", nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, server.ConnectionDataRequests())
+
+	assert.NoError(t, session.Delete(ctx))
+}
+
+func TestCreateSessionUnknownRunner(t *testing.T) {
+	server := fakeservice.NewServer()
+	defer server.Close()
+	instance, err := server.AddRunner("runner")
+	assert.NoError(t, err)
+	instance.Agent.Name = "removed"
+
+	vssConnection := &protocol.VssConnection{
+		TenantURL: instance.Auth.TenantURL,
+		PoolID:    instance.PoolID,
+		TaskAgent: instance.Agent,
+		Key:       instance.PKey,
+	}
+	_, err = vssConnection.CreateSession(context.Background())
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "TaskAgentNotFoundException")
+		assert.True(t, protocol.IsServiceError(err, protocol.TaskAgentNotFoundException))
+		var serr *protocol.ServiceError
+		if assert.ErrorAs(t, err, &serr) {
+			assert.Equal(t, 404, serr.StatusCode)
+			assert.False(t, serr.Temporary())
+		}
+	}
+}
+
+func TestTimelineAndLogs(t *testing.T) {
+	server := fakeservice.NewServer()
+	defer server.Close()
+	jobreq := server.NewJob("build")
+	vssConnection, _, err := jobreq.GetConnection("SystemVssConnection")
+	assert.NoError(t, err)
+
+	rec := protocol.CreateTimelineEntry(jobreq.JobID, "step", "Step")
+	rec.Start()
+	assert.NoError(t, vssConnection.UpdateTimeLine(jobreq.Timeline.ID, jobreq, &protocol.TimelineRecordWrapper{
+		Count: 1,
+		Value: []*protocol.TimelineRecord{&rec},
+	}))
+	logID, err := vssConnection.UploadLogFile(jobreq.Timeline.ID, jobreq, "Hello World\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello World\n", server.Log(logID))
+
+	rec.Complete("Succeeded")
+	rec.Log = &protocol.TaskLogReference{ID: logID}
+	assert.NoError(t, vssConnection.UpdateTimeLine(jobreq.Timeline.ID, jobreq, &protocol.TimelineRecordWrapper{
+		Count: 1,
+		Value: []*protocol.TimelineRecord{&rec},
+	}))
+	timeline := server.Timeline(jobreq.Timeline.ID)
+	if assert.Len(t, timeline, 1) {
+		assert.Equal(t, "Succeeded", *timeline[0].Result)
+		assert.Equal(t, logID, timeline[0].Log.ID)
+	}
+
+	assert.NoError(t, vssConnection.FinishJob(&protocol.JobEvent{
+		Name:      "JobCompleted",
+		JobID:     jobreq.JobID,
+		RequestID: jobreq.RequestID,
+		Result:    "Succeeded",
+	}, jobreq.Plan))
+	result, err := server.WaitForJob(jobreq.JobID, time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "Succeeded", result)
+}
+
+func TestCreateAttachment(t *testing.T) {
+	server := fakeservice.NewServer()
+	defer server.Close()
+	jobreq := server.NewJob("build")
+	vssConnection, _, err := jobreq.GetConnection("SystemVssConnection")
+	assert.NoError(t, err)
+
+	rec := protocol.CreateTimelineEntry(jobreq.JobID, "step", "Step")
+	attachment, err := vssConnection.CreateAttachment(context.Background(), jobreq.Timeline.ID, jobreq, rec.ID, protocol.StepSummaryAttachmentType, rec.ID, strings.NewReader("# Summary\n"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, protocol.StepSummaryAttachmentType, attachment.Type)
+		assert.Equal(t, rec.ID, attachment.RecordID)
+	}
+	assert.Equal(t, "# Summary\n", server.Attachment(rec.ID, protocol.StepSummaryAttachmentType, rec.ID))
+}
+
+func TestRetryReaderBody(t *testing.T) {
+	bodies := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if len(bodies) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	vssConnection := &protocol.VssConnection{
+		Token:       "token",
+		RetryPolicy: &protocol.RetryPolicy{MaxAttempts: 2, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+	}
+	assert.NoError(t, vssConnection.RequestWithContext2(context.Background(), "PUT", server.URL, "", strings.NewReader("content"), nil))
+	assert.Equal(t, []string{"content", "content"}, bodies, "the reader has to be sent again")
+}
@@ -0,0 +1,858 @@
+// Package fakeservice provides an in-memory fake of the GitHub Actions service, which is used to test the runner without network access
+package fakeservice
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+
+	// nolint:gosec
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ChristopherHX/github-act-runner/protocol"
+	"github.com/ChristopherHX/github-act-runner/protocol/results"
+	"github.com/ChristopherHX/github-act-runner/protocol/run"
+	"github.com/ChristopherHX/github-act-runner/runnerconfiguration"
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+)
+
+const (
+	PoolID = 1
+	// RunServicePath is the path of the run service, which serves jobs of type RunnerJobRequest
+	RunServicePath = "/run"
+	// ResultsPath is the path of the results service, set it as system.github.results_endpoint of a job to upload step summaries to it
+	ResultsPath = "/results"
+	// summaryBlobPath is the path of the signed urls of the step summaries
+	summaryBlobPath = "/blobs/summaries/"
+)
+
+var serviceDefinitions = []protocol.ServiceDefinition{
+	{ServiceType: "distributedtask", Identifier: "134e239e-2df3-4794-a6f6-24f1f19ec8dc", DisplayName: "sessions", RelativePath: "_apis/distributedtask/pools/{poolId}/sessions/{sessionId}"},
+	{ServiceType: "distributedtask", Identifier: "c3a054f6-7a8a-49c0-944e-3a8e5d7adfd7", DisplayName: "messages", RelativePath: "_apis/distributedtask/pools/{poolId}/messages/{messageId}"},
+	{ServiceType: "distributedtask", Identifier: "fc825784-c92a-4299-9221-998a02d1b54f", DisplayName: "jobrequests", RelativePath: "_apis/distributedtask/pools/{poolId}/jobrequests/{requestId}"},
+	{ServiceType: "distributedtask", Identifier: "e298ef32-5878-4cab-993c-043836571f42", DisplayName: "agents", RelativePath: "_apis/distributedtask/pools/{poolId}/agents/{agentId}"},
+	{ServiceType: "distributedtask", Identifier: "8893bc5b-35b2-4be7-83cb-99e683551db4", DisplayName: "records", RelativePath: "{scopeIdentifier}/_apis/distributedtask/hubs/{hubName}/plans/{planId}/timelines/{timelineId}/records"},
+	{ServiceType: "distributedtask", Identifier: "858983e4-19bd-4c5e-864c-507b59b58b12", DisplayName: "feed", RelativePath: "{scopeIdentifier}/_apis/distributedtask/hubs/{hubName}/plans/{planId}/timelines/{timelineId}/records/{recordId}/feed"},
+	{ServiceType: "distributedtask", Identifier: "46f5667d-263a-4684-91b1-dff7fdcf64e2", DisplayName: "logs", RelativePath: "{scopeIdentifier}/_apis/distributedtask/hubs/{hubName}/plans/{planId}/logs/{logId}"},
+	{ServiceType: "distributedtask", Identifier: "7898f959-9cdf-4096-b29e-7f293031629e", DisplayName: "attachments", RelativePath: "{scopeIdentifier}/_apis/distributedtask/hubs/{hubName}/plans/{planId}/timelines/{timelineId}/records/{recordId}/attachments/{type}/{name}"},
+	{ServiceType: "distributedtask", Identifier: "557624af-b29e-4c20-8ab0-0399d2204f3f", DisplayName: "events", RelativePath: "{scopeIdentifier}/_apis/distributedtask/hubs/{hubName}/plans/{planId}/events"},
+}
+
+type agent struct {
+	protocol.TaskAgent
+	key *rsa.PublicKey
+}
+
+type session struct {
+	protocol.TaskAgentSession
+	key []byte
+}
+
+type message struct {
+	protocol.TaskAgentMessage
+	body []byte
+}
+
+// Server fakes the message broker, the distributed task, the run service and the step summary endpoints of GitHub Actions
+type Server struct {
+	*httptest.Server
+	// PollTimeout is the time a message request waits for a new message, before it returns without one
+	PollTimeout time.Duration
+
+	mu            sync.Mutex
+	changed       chan struct{}
+	agents        map[string]*agent
+	tokens        map[string]bool
+	sessions      map[string]*session
+	messages      map[string][]*message
+	nextMessageID int64
+	failDeletes   int
+	failPolls     int
+	hidden        map[string]bool
+	connDataReqs  int
+	nextRequestID int64
+	nextLogID     int
+	acquirable    map[string]*protocol.AgentJobRequestMessage
+	timelines     map[string][]*protocol.TimelineRecord
+	logs          map[int]*bytes.Buffer
+	attachments   map[string][]byte
+	blobs         map[string][]byte
+	summaries     map[string][]byte
+	feed          map[string][]string
+	requests      map[string]string
+	renewals      map[string]int
+	results       map[string]string
+	finished      []*protocol.JobEvent
+	completed     []*run.CompleteJobRequest
+}
+
+// NewServer starts a new fake service, call Close to stop it
+func NewServer() *Server {
+	s := &Server{
+		PollTimeout: 100 * time.Millisecond,
+		changed:     make(chan struct{}),
+		agents:      map[string]*agent{},
+		tokens:      map[string]bool{},
+		sessions:    map[string]*session{},
+		messages:    map[string][]*message{},
+		acquirable:  map[string]*protocol.AgentJobRequestMessage{},
+		timelines:   map[string][]*protocol.TimelineRecord{},
+		logs:        map[int]*bytes.Buffer{},
+		attachments: map[string][]byte{},
+		blobs:       map[string][]byte{},
+		summaries:   map[string][]byte{},
+		feed:        map[string][]string{},
+		requests:    map[string]string{},
+		renewals:    map[string]int{},
+		results:     map[string]string{},
+		hidden:      map[string]bool{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// notify requires the lock to be held
+func (s *Server) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// AddRunner registers a new runner, the returned instance can be used to run the runner against this server
+func (s *Server) AddRunner(name string) (*runnerconfiguration.RunnerInstance, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	taskAgent := &protocol.TaskAgent{
+		Name:    name,
+		Version: "3.0.0",
+	}
+	taskAgent.Authorization.AuthorizationURL = s.URL + "/_apis/oauth2/token"
+	taskAgent.Authorization.ClientID = uuid.NewString()
+	taskAgent.Authorization.PublicKey = protocol.TaskAgentPublicKey{
+		Exponent: base64.StdEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		Modulus:  base64.StdEncoding.EncodeToString(key.N.Bytes()),
+	}
+	s.mu.Lock()
+	taskAgent.ID = len(s.agents) + 1
+	s.agents[name] = &agent{TaskAgent: *taskAgent, key: &key.PublicKey}
+	s.mu.Unlock()
+	return &runnerconfiguration.RunnerInstance{
+		PoolID:          PoolID,
+		RegistrationURL: s.URL + "/owner/" + name,
+		Auth: &protocol.GitHubAuthResult{
+			TenantURL:   s.URL,
+			TokenSchema: "OAuthAccessToken",
+		},
+		Agent: taskAgent,
+		Key:   base64.StdEncoding.EncodeToString(x509.MarshalPKCS1PrivateKey(key)),
+		PKey:  key,
+	}, nil
+}
+
+// NewJob creates a job request without steps, which can be queued by QueueJob or QueueRunServiceJob
+func (s *Server) NewJob(displayName string) *protocol.AgentJobRequestMessage {
+	token := uuid.NewString()
+	jobID := uuid.NewString()
+	s.mu.Lock()
+	s.nextRequestID++
+	requestID := s.nextRequestID
+	s.requests[fmt.Sprint(requestID)] = jobID
+	s.tokens[token] = true
+	s.mu.Unlock()
+	return &protocol.AgentJobRequestMessage{
+		MessageType: "PipelineAgentJobRequest",
+		Plan: &protocol.TaskOrchestrationPlanReference{
+			ScopeIdentifier: uuid.NewString(),
+			PlanID:          uuid.NewString(),
+			PlanType:        "actions",
+		},
+		Timeline:       &protocol.TimeLineReference{ID: uuid.NewString()},
+		JobID:          jobID,
+		JobDisplayName: displayName,
+		JobName:        "__default",
+		RequestID:      requestID,
+		Resources: &protocol.JobResources{
+			Endpoints: []protocol.JobEndpoint{
+				{
+					Name: "SystemVssConnection",
+					URL:  s.URL,
+					Authorization: protocol.JobAuthorization{
+						Scheme:     "OAuth",
+						Parameters: map[string]string{"AccessToken": token},
+					},
+					Data: map[string]string{},
+				},
+			},
+		},
+		Variables: map[string]protocol.VariableValue{},
+	}
+}
+
+// QueueMessage queues a message for a runner, the body is encrypted with the session key on delivery
+func (s *Server) QueueMessage(runnerName string, messageType string, body interface{}) error {
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextMessageID++
+	s.messages[runnerName] = append(s.messages[runnerName], &message{
+		TaskAgentMessage: protocol.TaskAgentMessage{
+			MessageID:   s.nextMessageID,
+			MessageType: messageType,
+		},
+		body: raw,
+	})
+	s.notify()
+	return nil
+}
+
+// QueueJob queues a job of type PipelineAgentJobRequest
+func (s *Server) QueueJob(runnerName string, jobreq *protocol.AgentJobRequestMessage) error {
+	return s.QueueMessage(runnerName, jobreq.MessageType, jobreq)
+}
+
+// QueueRunServiceJob queues a job of type RunnerJobRequest, which has to be acquired from the run service
+func (s *Server) QueueRunServiceJob(runnerName string, jobreq *protocol.AgentJobRequestMessage) error {
+	jobreq.MessageType = "RunnerJobRequest"
+	for i := range jobreq.Resources.Endpoints {
+		if jobreq.Resources.Endpoints[i].Name == "SystemVssConnection" {
+			jobreq.Resources.Endpoints[i].URL = s.URL + RunServicePath
+		}
+	}
+	messageID := uuid.NewString()
+	s.mu.Lock()
+	s.acquirable[messageID] = jobreq
+	s.mu.Unlock()
+	return s.QueueMessage(runnerName, "RunnerJobRequest", map[string]string{
+		"id":                messageID,
+		"runner_request_id": messageID,
+		"run_service_url":   s.URL + RunServicePath,
+	})
+}
+
+// FailMessageDeletes lets the next n requests to delete a message fail, the messages are delivered again
+func (s *Server) FailMessageDeletes(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failDeletes = n
+}
+
+// FailMessagePolls lets the next n requests to get a message fail
+func (s *Server) FailMessagePolls(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failPolls = n
+}
+
+// HideService removes the service id from the connectionData, like an older or newer server without it
+func (s *Server) HideService(id string, hidden bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hidden[id] = hidden
+}
+
+// ConnectionDataRequests returns how often the connectionData has been requested
+func (s *Server) ConnectionDataRequests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connDataReqs
+}
+
+// CancelJob queues a JobCancellation message
+func (s *Server) CancelJob(runnerName string, jobreq *protocol.AgentJobRequestMessage) error {
+	return s.QueueMessage(runnerName, "JobCancellation", map[string]string{
+		"JobId": jobreq.JobID,
+	})
+}
+
+// WaitForJob waits until the runner finished the job and returns its result
+func (s *Server) WaitForJob(jobID string, timeout time.Duration) (string, error) {
+	deadline := time.After(timeout)
+	for {
+		s.mu.Lock()
+		result, ok := s.results[jobID]
+		changed := s.changed
+		s.mu.Unlock()
+		if ok {
+			return result, nil
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			return "", fmt.Errorf("job %v didn't finish within %v", jobID, timeout)
+		}
+	}
+}
+
+// Timeline returns a copy of all timeline records of the timeline
+func (s *Server) Timeline(timelineID string) []protocol.TimelineRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records := make([]protocol.TimelineRecord, len(s.timelines[timelineID]))
+	for i, rec := range s.timelines[timelineID] {
+		records[i] = *rec
+	}
+	return records
+}
+
+// Log returns the content of an uploaded log file
+func (s *Server) Log(logID int) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if buf, ok := s.logs[logID]; ok {
+		return buf.String()
+	}
+	return ""
+}
+
+// Attachment returns the content of an attachment of a timeline record
+func (s *Server) Attachment(recordID string, attachmentType string, name string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return string(s.attachments[recordID+"/"+attachmentType+"/"+name])
+}
+
+// StepSummary returns the step summary of a timeline record uploaded to the results service
+func (s *Server) StepSummary(recordID string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return string(s.summaries[recordID])
+}
+
+// FeedLines returns all live log lines of a timeline record
+func (s *Server) FeedLines(recordID string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.feed[recordID]...)
+}
+
+// Renewals returns the number of renew requests of a job
+func (s *Server) Renewals(jobID string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.renewals[jobID]
+}
+
+// FinishedJobs returns all jobs finished via the legacy FinishJob event
+func (s *Server) FinishedJobs() []protocol.JobEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	events := make([]protocol.JobEvent, len(s.finished))
+	for i, e := range s.finished {
+		events[i] = *e
+	}
+	return events
+}
+
+// CompletedJobs returns all jobs finished via the run service
+func (s *Server) CompletedJobs() []run.CompleteJobRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reqs := make([]run.CompleteJobRequest, len(s.completed))
+	for i, r := range s.completed {
+		reqs[i] = *r
+	}
+	return reqs
+}
+
+// Sessions returns the number of active sessions
+func (s *Server) Sessions() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sessions)
+}
+
+func writeJson(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, typeKey string, msg string) {
+	writeJson(w, status, map[string]interface{}{
+		"$id":       "1",
+		"message":   msg,
+		"typeName":  "GitHub.DistributedTask.WebApi." + typeKey + ", GitHub.DistributedTask.WebApi",
+		"typeKey":   typeKey,
+		"errorCode": 0,
+	})
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "bearer ") {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tokens[auth[7:]]
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	apis := -1
+	for i, seg := range segments {
+		if seg == "_apis" {
+			apis = i
+			break
+		}
+	}
+	if apis == -1 {
+		if strings.HasPrefix(r.URL.Path, summaryBlobPath) && r.Method == "PUT" {
+			// signed urls don't require the access token
+			content, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "ArgumentException", err.Error())
+				return
+			}
+			s.mu.Lock()
+			s.blobs[strings.TrimPrefix(r.URL.Path, summaryBlobPath)] = content
+			s.mu.Unlock()
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, ResultsPath+"/") {
+			if !s.authorized(r) {
+				writeError(w, http.StatusUnauthorized, "UnauthorizedException", "missing or invalid access token")
+				return
+			}
+			s.serveResults(w, r, segments[len(segments)-1])
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, RunServicePath+"/") {
+			if !s.authorized(r) {
+				writeError(w, http.StatusUnauthorized, "UnauthorizedException", "missing or invalid access token")
+				return
+			}
+			s.serveRunService(w, r, segments[len(segments)-1])
+			return
+		}
+		http.NotFound(w, r)
+		return
+	}
+	route := segments[apis+1:]
+	if len(route) == 2 && route[0] == "oauth2" && route[1] == "token" {
+		s.serveToken(w, r)
+		return
+	}
+	if !s.authorized(r) {
+		writeError(w, http.StatusUnauthorized, "UnauthorizedException", "missing or invalid access token")
+		return
+	}
+	if len(route) == 1 && route[0] == "connectionData" {
+		s.mu.Lock()
+		s.connDataReqs++
+		definitions := []protocol.ServiceDefinition{}
+		for _, def := range serviceDefinitions {
+			if !s.hidden[def.Identifier] {
+				definitions = append(definitions, def)
+			}
+		}
+		s.mu.Unlock()
+		writeJson(w, http.StatusOK, &protocol.ConnectionData{
+			LocationServiceData: protocol.LocationServiceData{ServiceDefinitions: definitions},
+		})
+		return
+	}
+	if len(route) >= 4 && route[0] == "distributedtask" && route[1] == "pools" {
+		var id string
+		if len(route) > 4 {
+			id = route[4]
+		}
+		switch route[3] {
+		case "sessions":
+			s.serveSessions(w, r, id)
+			return
+		case "messages":
+			s.serveMessages(w, r, id)
+			return
+		case "jobrequests":
+			s.mu.Lock()
+			s.renewals[s.requests[id]]++
+			s.mu.Unlock()
+			writeJson(w, http.StatusOK, &protocol.RenewAgent{})
+			return
+		case "agents":
+			s.mu.Lock()
+			for name, a := range s.agents {
+				if fmt.Sprint(a.ID) == id {
+					delete(s.agents, name)
+				}
+			}
+			s.mu.Unlock()
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+	}
+	if len(route) >= 6 && route[0] == "distributedtask" && route[1] == "hubs" && route[3] == "plans" {
+		s.servePlan(w, r, route[5:])
+		return
+	}
+	http.NotFound(w, r)
+}
+
+func (s *Server) serveToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeJson(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	_, err := jwt.Parse(r.PostForm.Get("client_assertion"), func(token *jwt.Token) (interface{}, error) {
+		claims, _ := token.Claims.(jwt.MapClaims)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, a := range s.agents {
+			if claims != nil && claims["sub"] == a.Authorization.ClientID {
+				return a.key, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown client")
+	})
+	if err != nil {
+		writeJson(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client", "error_description": err.Error()})
+		return
+	}
+	token := uuid.NewString()
+	s.mu.Lock()
+	s.tokens[token] = true
+	s.mu.Unlock()
+	writeJson(w, http.StatusOK, &protocol.VssOAuthTokenResponse{
+		AccessToken: token,
+		ExpiresIn:   3600,
+		TokenType:   "bearer",
+	})
+}
+
+func (s *Server) serveSessions(w http.ResponseWriter, r *http.Request, id string) {
+	switch r.Method {
+	case "POST":
+		sess := &session{}
+		if err := json.NewDecoder(r.Body).Decode(&sess.TaskAgentSession); err != nil {
+			writeError(w, http.StatusBadRequest, "ArgumentException", err.Error())
+			return
+		}
+		s.mu.Lock()
+		a, ok := s.agents[sess.Agent.Name]
+		s.mu.Unlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, "TaskAgentNotFoundException", "No agent found with name "+sess.Agent.Name)
+			return
+		}
+		sess.key = make([]byte, 32)
+		if _, err := rand.Read(sess.key); err != nil {
+			writeError(w, http.StatusInternalServerError, "Exception", err.Error())
+			return
+		}
+		// nolint:gosec // Same as the actions service, if UseFipsEncryption is false
+		encrypted, err := rsa.EncryptOAEP(sha1.New(), rand.Reader, a.key, sess.key, []byte{})
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Exception", err.Error())
+			return
+		}
+		sess.SessionID = uuid.NewString()
+		sess.EncryptionKey = protocol.TaskAgentSessionKey{
+			Encrypted: true,
+			Value:     base64.StdEncoding.EncodeToString(encrypted),
+		}
+		sess.UseFipsEncryption = false
+		s.mu.Lock()
+		s.sessions[sess.SessionID] = sess
+		s.mu.Unlock()
+		writeJson(w, http.StatusOK, &sess.TaskAgentSession)
+	case "DELETE":
+		s.mu.Lock()
+		delete(s.sessions, id)
+		s.notify()
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func encrypt(key []byte, plain []byte) (string, string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", "", err
+	}
+	iv := make([]byte, block.BlockSize())
+	if _, err := rand.Read(iv); err != nil {
+		return "", "", err
+	}
+	padding := block.BlockSize() - len(plain)%block.BlockSize()
+	src := append(append([]byte{}, plain...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(src, src)
+	return base64.StdEncoding.EncodeToString(iv), base64.StdEncoding.EncodeToString(src), nil
+}
+
+func (s *Server) serveMessages(w http.ResponseWriter, r *http.Request, id string) {
+	sessionID := r.URL.Query().Get("sessionId")
+	s.mu.Lock()
+	sess, ok := s.sessions[sessionID]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "TaskAgentSessionExpiredException", "The session "+sessionID+" has expired")
+		return
+	}
+	switch r.Method {
+	case "GET":
+		s.mu.Lock()
+		if s.failPolls > 0 {
+			s.failPolls--
+			s.mu.Unlock()
+			writeError(w, http.StatusInternalServerError, "Exception", "failed to get a message")
+			return
+		}
+		s.mu.Unlock()
+		timeout := time.After(s.PollTimeout)
+		for {
+			s.mu.Lock()
+			var msg *message
+			if queue := s.messages[sess.Agent.Name]; len(queue) > 0 {
+				msg = queue[0]
+			}
+			_, alive := s.sessions[sessionID]
+			changed := s.changed
+			s.mu.Unlock()
+			if !alive {
+				writeError(w, http.StatusNotFound, "TaskAgentSessionExpiredException", "The session "+sessionID+" has expired")
+				return
+			}
+			if msg != nil {
+				reply := msg.TaskAgentMessage
+				var err error
+				if reply.IV, reply.Body, err = encrypt(sess.key, msg.body); err != nil {
+					writeError(w, http.StatusInternalServerError, "Exception", err.Error())
+					return
+				}
+				writeJson(w, http.StatusOK, &reply)
+				return
+			}
+			select {
+			case <-changed:
+			case <-timeout:
+				w.WriteHeader(http.StatusAccepted)
+				return
+			case <-r.Context().Done():
+				return
+			}
+		}
+	case "DELETE":
+		s.mu.Lock()
+		if s.failDeletes > 0 {
+			s.failDeletes--
+			s.mu.Unlock()
+			writeError(w, http.StatusInternalServerError, "Exception", "failed to delete message "+id)
+			return
+		}
+		queue := s.messages[sess.Agent.Name]
+		for i, msg := range queue {
+			if fmt.Sprint(msg.MessageID) == id {
+				s.messages[sess.Agent.Name] = append(queue[:i:i], queue[i+1:]...)
+				break
+			}
+		}
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) servePlan(w http.ResponseWriter, r *http.Request, route []string) {
+	switch {
+	case len(route) == 3 && route[0] == "timelines" && route[2] == "records" && r.Method == "PATCH":
+		wrapper := &protocol.TimelineRecordWrapper{}
+		if err := json.NewDecoder(r.Body).Decode(wrapper); err != nil {
+			writeError(w, http.StatusBadRequest, "ArgumentException", err.Error())
+			return
+		}
+		s.mu.Lock()
+		records := s.timelines[route[1]]
+		for _, rec := range wrapper.Value {
+			found := false
+			for i, existing := range records {
+				if existing.ID == rec.ID {
+					records[i] = rec
+					found = true
+					break
+				}
+			}
+			if !found {
+				records = append(records, rec)
+			}
+		}
+		s.timelines[route[1]] = records
+		s.notify()
+		s.mu.Unlock()
+		writeJson(w, http.StatusOK, wrapper)
+	case len(route) == 5 && route[0] == "timelines" && route[4] == "feed" && r.Method == "POST":
+		lines := &protocol.TimelineRecordFeedLinesWrapper{}
+		if err := json.NewDecoder(r.Body).Decode(lines); err != nil {
+			writeError(w, http.StatusBadRequest, "ArgumentException", err.Error())
+			return
+		}
+		s.mu.Lock()
+		s.feed[route[3]] = append(s.feed[route[3]], lines.Value...)
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	case len(route) == 7 && route[0] == "timelines" && route[4] == "attachments" && r.Method == "PUT":
+		content, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "ArgumentException", err.Error())
+			return
+		}
+		s.mu.Lock()
+		s.attachments[route[3]+"/"+route[5]+"/"+route[6]] = content
+		s.mu.Unlock()
+		writeJson(w, http.StatusOK, &protocol.TaskAttachment{Type: route[5], Name: route[6], TimelineID: route[1], RecordID: route[3]})
+	case len(route) == 1 && route[0] == "logs" && r.Method == "POST":
+		log := &protocol.TaskLog{}
+		if err := json.NewDecoder(r.Body).Decode(log); err != nil {
+			writeError(w, http.StatusBadRequest, "ArgumentException", err.Error())
+			return
+		}
+		s.mu.Lock()
+		s.nextLogID++
+		log.ID = s.nextLogID
+		s.logs[log.ID] = &bytes.Buffer{}
+		s.mu.Unlock()
+		writeJson(w, http.StatusOK, log)
+	case len(route) == 2 && route[0] == "logs" && r.Method == "POST":
+		logID, _ := strconv.Atoi(route[1])
+		content, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "ArgumentException", err.Error())
+			return
+		}
+		s.mu.Lock()
+		buf, ok := s.logs[logID]
+		if ok {
+			buf.Write(content)
+		}
+		s.mu.Unlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, "TaskLogNotFoundException", "log "+route[1]+" not found")
+			return
+		}
+		writeJson(w, http.StatusOK, &protocol.TaskLog{TaskLogReference: protocol.TaskLogReference{ID: logID}})
+	case len(route) == 1 && route[0] == "events" && r.Method == "POST":
+		e := &protocol.JobEvent{}
+		if err := json.NewDecoder(r.Body).Decode(e); err != nil {
+			writeError(w, http.StatusBadRequest, "ArgumentException", err.Error())
+			return
+		}
+		s.mu.Lock()
+		s.finished = append(s.finished, e)
+		s.results[e.JobID] = e.Result
+		s.notify()
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) serveRunService(w http.ResponseWriter, r *http.Request, endpoint string) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	switch endpoint {
+	case "acquirejob":
+		req := &run.AcquireJobRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			writeError(w, http.StatusBadRequest, "ArgumentException", err.Error())
+			return
+		}
+		s.mu.Lock()
+		jobreq, ok := s.acquirable[req.JobMessageID]
+		s.mu.Unlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, "JobNotFoundException", "job message "+req.JobMessageID+" not found")
+			return
+		}
+		writeJson(w, http.StatusOK, jobreq)
+	case "renewjob":
+		req := &run.RenewJobRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			writeError(w, http.StatusBadRequest, "ArgumentException", err.Error())
+			return
+		}
+		s.mu.Lock()
+		s.renewals[req.JobID]++
+		s.mu.Unlock()
+		writeJson(w, http.StatusOK, &run.RenewJobResponse{LockedUntil: time.Now().Add(10 * time.Minute)})
+	case "completejob":
+		req := &run.CompleteJobRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			writeError(w, http.StatusBadRequest, "ArgumentException", err.Error())
+			return
+		}
+		s.mu.Lock()
+		s.completed = append(s.completed, req)
+		s.results[req.JobID] = req.Conclusion
+		s.notify()
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveResults serves the step summary endpoints of the results service
+func (s *Server) serveResults(w http.ResponseWriter, r *http.Request, endpoint string) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	switch endpoint {
+	case "GetStepSummarySignedBlobURL":
+		req := &results.GetSignedStepSummaryURLRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			writeError(w, http.StatusBadRequest, "ArgumentException", err.Error())
+			return
+		}
+		writeJson(w, http.StatusOK, &results.GetSignedStepSummaryURLResponse{
+			SummaryUrl:      s.URL + summaryBlobPath + req.StepBackendId,
+			SoftSizeLimit:   1024 * 1024,
+			BlobStorageType: results.BlobStorageTypeUnspecified,
+		})
+	case "CreateStepSummaryMetadata":
+		req := &results.StepSummaryMetadataCreate{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			writeError(w, http.StatusBadRequest, "ArgumentException", err.Error())
+			return
+		}
+		s.mu.Lock()
+		content, ok := s.blobs[req.StepBackendId]
+		if ok {
+			s.summaries[req.StepBackendId] = content
+		}
+		s.mu.Unlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, "NotFoundException", "step summary of "+req.StepBackendId+" not uploaded")
+			return
+		}
+		writeJson(w, http.StatusOK, map[string]interface{}{"ok": true})
+	default:
+		http.NotFound(w, r)
+	}
+}
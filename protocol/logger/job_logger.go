@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"context"
 	"errors"
-	"fmt"
 	"io"
 	"net/http"
 	"net/url"
@@ -13,6 +12,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/ChristopherHX/github-act-runner/metrics"
 	"github.com/ChristopherHX/github-act-runner/protocol"
 	"github.com/ChristopherHX/github-act-runner/protocol/results"
 	"nhooyr.io/websocket"
@@ -56,10 +56,10 @@ func (logger *WebsocketLivelogger) Close() error {
 func (logger *WebsocketLivelogger) Connect() error {
 	err := logger.Close()
 	if err != nil && logger.Connection.Trace {
-		fmt.Printf("Failed to close old websocket connection %s\n", err.Error())
+		logger.Connection.GetLogger().Debugf("Failed to close old websocket connection %s", err.Error())
 	}
 	if logger.Connection.Trace {
-		fmt.Printf("Try to connect to websocket %s\n", logger.FeedStreamUrl)
+		logger.Connection.GetLogger().Debugf("Try to connect to websocket %s", logger.FeedStreamUrl)
 	}
 	re := regexp.MustCompile("(?i)^http(s?)://")
 	feedStreamUrl, err := url.Parse(re.ReplaceAllString(logger.FeedStreamUrl, "ws$1://"))
@@ -68,10 +68,14 @@ func (logger *WebsocketLivelogger) Connect() error {
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*5)
 	defer cancel()
+	token, err := logger.Connection.AccessToken(ctx)
+	if err != nil {
+		return err
+	}
 	logger.ws, _, err = websocket.Dial(ctx, feedStreamUrl.String(), &websocket.DialOptions{
 		HTTPClient: logger.Connection.HttpClient(),
 		HTTPHeader: http.Header{
-			"Authorization": []string{"Bearer " + logger.Connection.Token},
+			"Authorization": []string{"Bearer " + token},
 			"User-Agent":    []string{"github-act-runner/1.0.0"},
 		},
 	})
@@ -113,7 +117,7 @@ func (logger *WebsocketLiveloggerWithFallback) Initialize() {
 			logger.currentLogger = wslogger
 			return
 		} else if logger.Connection.Trace {
-			fmt.Printf("Failed to connect to websocket %s, fallback to vsslogger\n", err.Error())
+			logger.Connection.GetLogger().Debugf("Failed to connect to websocket %s, fallback to vsslogger", err.Error())
 		}
 	}
 	if !logger.ForceWebsock {
@@ -137,13 +141,13 @@ func (logger *WebsocketLiveloggerWithFallback) SendLog(wrapper *protocol.Timelin
 	err := logger.currentLogger.SendLog(wrapper)
 	if err != nil {
 		if logger.Connection.Trace {
-			fmt.Printf("Failed to send webconsole log %s\n", err.Error())
+			logger.Connection.GetLogger().Debugf("Failed to send webconsole log %s", err.Error())
 		}
 		if wslogger, err := logger.currentLogger.(*WebsocketLivelogger); err {
 			if err := wslogger.Connect(); err != nil {
 				if !logger.ForceWebsock {
 					if logger.Connection.Trace {
-						fmt.Printf("Failed to reconnect to websocket %s, fallback to vsslogger\n", err.Error())
+						logger.Connection.GetLogger().Debugf("Failed to reconnect to websocket %s, fallback to vsslogger", err.Error())
 					}
 					logger.InitializeVssLogger()
 					return logger.currentLogger.SendLog(wrapper)
@@ -154,7 +158,7 @@ func (logger *WebsocketLiveloggerWithFallback) SendLog(wrapper *protocol.Timelin
 			if err != nil {
 				if !logger.ForceWebsock {
 					if logger.Connection.Trace {
-						fmt.Printf("Failed to send webconsole log %s, fallback to vsslogger\n", err.Error())
+						logger.Connection.GetLogger().Debugf("Failed to send webconsole log %s, fallback to vsslogger", err.Error())
 					}
 					logger.InitializeVssLogger()
 					return logger.currentLogger.SendLog(wrapper)
@@ -167,6 +171,8 @@ func (logger *WebsocketLiveloggerWithFallback) SendLog(wrapper *protocol.Timelin
 	return err
 }
 
+var logUploadQueueDepth = metrics.NewGaugeVec("github_act_runner_log_upload_queue_depth", "Number of live log batches waiting to be sent by all jobs")
+
 type BufferedLiveLogger struct {
 	LiveLogger
 	logchan     chan *protocol.TimelineRecordFeedLinesWrapper
@@ -180,6 +186,7 @@ func (logger *BufferedLiveLogger) sendLogs(logchan chan *protocol.TimelineRecord
 		if !ok {
 			return
 		}
+		logUploadQueueDepth.Add(-1)
 		st := time.Now()
 		lp := st
 		logsexit := false
@@ -192,6 +199,7 @@ func (logger *BufferedLiveLogger) sendLogs(logchan chan *protocol.TimelineRecord
 			select {
 			case line, ok := <-logchan:
 				if ok {
+					logUploadQueueDepth.Add(-1)
 					if line.StepID == lines.StepID {
 						lines.Count += line.Count
 						lines.Value = append(lines.Value, line.Value...)
@@ -235,6 +243,7 @@ func (logger *BufferedLiveLogger) SendLog(wrapper *protocol.TimelineRecordFeedLi
 		logger.logfinished = logfinished
 		go logger.sendLogs(logchan, logfinished)
 	}
+	logUploadQueueDepth.Add(1)
 	logger.logchan <- wrapper
 	return nil
 }
@@ -355,6 +364,26 @@ func (logger *JobLogger) uploadJobBlob(finalBlock bool) {
 	}
 }
 
+// CanUploadStepSummary returns true if the job has a service for the summaries of its steps
+func (logger *JobLogger) CanUploadStepSummary() bool {
+	return logger.ResultsConnection != nil || !logger.IsResults
+}
+
+// UploadStepSummary uploads the markdown summary of a step to the results service, otherwise it becomes an attachment of the timeline record
+func (logger *JobLogger) UploadStepSummary(ctx context.Context, rec *protocol.TimelineRecord, summary []byte) error {
+	if logger.ResultsConnection != nil {
+		rs := &results.ResultsService{
+			Connection: logger.ResultsConnection,
+		}
+		return rs.UploadResultsStepSummaryAsync(ctx, logger.JobRequest.Plan.PlanID, logger.JobRequest.JobID, rec.ID, bytes.NewReader(summary), int64(len(summary)))
+	}
+	if logger.IsResults {
+		return errors.New("step summaries require the results service")
+	}
+	_, err := logger.Connection.CreateAttachment(ctx, logger.JobRequest.Timeline.ID, logger.JobRequest, rec.ID, protocol.StepSummaryAttachmentType, rec.ID, bytes.NewReader(summary))
+	return err
+}
+
 func (logger *JobLogger) Update() error {
 	logger.loggersync.Lock()
 	defer logger.loggersync.Unlock()
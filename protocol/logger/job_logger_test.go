@@ -1,9 +1,11 @@
 package logger
 
 import (
+	"context"
 	"testing"
 
 	"github.com/ChristopherHX/github-act-runner/protocol"
+	"github.com/ChristopherHX/github-act-runner/protocol/fakeservice"
 )
 
 func TestJobLogger(t *testing.T) {
@@ -26,3 +28,74 @@ func TestJobLogger(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestJobLoggerUpload(t *testing.T) {
+	server := fakeservice.NewServer()
+	defer server.Close()
+	jobreq := server.NewJob("build")
+	con, _, err := jobreq.GetConnection("SystemVssConnection")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := &JobLogger{
+		JobRequest:      jobreq,
+		Connection:      con,
+		TimelineRecords: &protocol.TimelineRecordWrapper{},
+		Logger:          &BufferedLiveLogger{LiveLogger: &VssLiveLogger{JobRequest: jobreq, Connection: con}},
+	}
+	job := logger.Append(protocol.CreateTimelineEntry("", jobreq.JobName, jobreq.JobDisplayName))
+	job.ID = jobreq.JobID
+	job.Start()
+	step := logger.Append(protocol.CreateTimelineEntry(jobreq.JobID, "step", "Step"))
+	logger.MoveNext()
+	logger.Log("Hello\nWorld")
+	step.Complete("Succeeded")
+	logger.MoveNext()
+	job.Complete("Succeeded")
+	logger.Logger.Close()
+	logger.Finish()
+
+	timeline := server.Timeline(jobreq.Timeline.ID)
+	if len(timeline) != 2 {
+		t.Fatalf("expected 2 timeline records, got %v", len(timeline))
+	}
+	if timeline[1].Log == nil || server.Log(timeline[1].Log.ID) != "Hello\nWorld\n" {
+		t.Errorf("step log has not been uploaded")
+	}
+	if timeline[0].Log == nil || server.Log(timeline[0].Log.ID) != "Hello\nWorld\n" {
+		t.Errorf("job log has not been uploaded")
+	}
+	if lines := server.FeedLines(step.ID); len(lines) != 2 || lines[0] != "Hello" || lines[1] != "World" {
+		t.Errorf("unexpected live log lines %v", lines)
+	}
+}
+
+func TestJobLoggerUploadStepSummary(t *testing.T) {
+	server := fakeservice.NewServer()
+	defer server.Close()
+	jobreq := server.NewJob("build")
+	con, _, err := jobreq.GetConnection("SystemVssConnection")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := &JobLogger{
+		JobRequest:      jobreq,
+		Connection:      con,
+		TimelineRecords: &protocol.TimelineRecordWrapper{},
+	}
+	if !logger.CanUploadStepSummary() {
+		t.Fatal("expected step summaries to be attached to the timeline")
+	}
+	step := logger.Append(protocol.CreateTimelineEntry(jobreq.JobID, "step", "Step"))
+	if err := logger.UploadStepSummary(context.Background(), step, []byte("# Summary")); err != nil {
+		t.Fatal(err)
+	}
+	if summary := server.Attachment(step.ID, protocol.StepSummaryAttachmentType, step.ID); summary != "# Summary" {
+		t.Errorf("unexpected step summary %q", summary)
+	}
+
+	logger.IsResults = true
+	if logger.CanUploadStepSummary() {
+		t.Error("step summaries of the run service require the results service")
+	}
+}
@@ -0,0 +1,102 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ChristopherHX/github-act-runner/common"
+)
+
+// MessageListener long polls the messages of a session, it is not safe for concurrent use.
+// Every message is returned only once, even if the actions service delivers it again after a failed Acknowledge.
+type MessageListener struct {
+	Session *AgentMessageConnection
+	// Backoff delays GetNextMessage after failed polls
+	Backoff       common.Backoff
+	lastMessageID int64
+}
+
+// NewMessageListener creates a listener with a backoff between 1 second and 1 minute
+func NewMessageListener(session *AgentMessageConnection) *MessageListener {
+	return &MessageListener{
+		Session: session,
+		Backoff: common.Backoff{Min: time.Second, Max: time.Minute},
+	}
+}
+
+// LastMessageID is the id of the last acknowledged message
+func (l *MessageListener) LastMessageID() int64 {
+	return l.lastMessageID
+}
+
+// Poll waits for the next message, it returns io.EOF if the actions service ended the long poll without a message
+func (l *MessageListener) Poll(ctx context.Context) (*TaskAgentMessage, error) {
+	session := l.Session
+	// Failed polls are retried by GetNextMessage with the Backoff of the listener
+	pollCtx := WithRetryPolicy(ctx, NoRetryPolicy)
+	for {
+		query := map[string]string{
+			"sessionId": session.TaskAgentSession.SessionID,
+		}
+		if l.lastMessageID > 0 {
+			query["lastMessageId"] = fmt.Sprint(l.lastMessageID)
+		}
+		message := &TaskAgentMessage{}
+		err := session.VssConnection.RequestWithContext(pollCtx, "c3a054f6-7a8a-49c0-944e-3a8e5d7adfd7", "5.1-preview", "GET", map[string]string{
+			"poolId": fmt.Sprint(session.VssConnection.PoolID),
+		}, query, nil, message)
+		if err != nil {
+			return nil, err
+		}
+		if message.MessageID > l.lastMessageID {
+			return message, nil
+		}
+		session.VssConnection.GetLogger().WithField(common.LogFieldSessionID, session.TaskAgentSession.SessionID).Infof("Skip message %v of type %v, it has already been received", message.MessageID, message.MessageType)
+		// Deleting it failed before
+		if err := session.DeleteMessage(ctx, message); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Acknowledge marks the message as received and deletes it from the actions service,
+// it won't be returned again even if deleting failed
+func (l *MessageListener) Acknowledge(ctx context.Context, message *TaskAgentMessage) error {
+	if message.MessageID > l.lastMessageID {
+		l.lastMessageID = message.MessageID
+	}
+	return l.Session.DeleteMessage(ctx, message)
+}
+
+// GetNextMessage polls until it receives a message, errors are retried with the Backoff
+func (l *MessageListener) GetNextMessage(ctx context.Context) (*TaskAgentMessage, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, context.Canceled
+		default:
+		}
+		message, err := l.Poll(ctx)
+		if err == nil {
+			l.Backoff.Reset()
+			return message, nil
+		}
+		if errors.Is(err, context.Canceled) {
+			return nil, err
+		} else if !errors.Is(err, io.EOF) {
+			delay := l.Backoff.Next()
+			if retryAfter := RetryAfter(err); retryAfter > delay {
+				delay = retryAfter
+			}
+			l.Session.VssConnection.GetLogger().WithField(common.LogFieldSessionID, l.Session.TaskAgentSession.SessionID).Warnf("Failed to get message, waiting %v before retry: %v", delay.Round(time.Second), err.Error())
+			select {
+			case <-ctx.Done():
+				return nil, context.Canceled
+			case <-time.After(delay):
+			}
+		}
+	}
+}
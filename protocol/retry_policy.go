@@ -0,0 +1,76 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// RetryPolicy controls which failed requests of a VssConnection are sent again and how long to wait in between
+type RetryPolicy struct {
+	// MaxAttempts is the number of tries of a request including the first one, values below 2 disable retries
+	MaxAttempts int
+	// Budget limits the time spent waiting for retries of a single call, zero only applies the limit of the context
+	Budget time.Duration
+	// MinBackoff and MaxBackoff bound the exponential delay between attempts, a longer Retry-After or rate limit reset of the service takes precedence
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// AllMethods retries POST and PATCH requests after connection failures and server errors as well,
+	// only use it for requests the actions service can safely receive twice
+	AllMethods bool
+}
+
+var (
+	// DefaultRetryPolicy is used by connections without a RetryPolicy
+	DefaultRetryPolicy = &RetryPolicy{MaxAttempts: 5, Budget: time.Minute, MinBackoff: time.Second, MaxBackoff: 15 * time.Second}
+	// PersistentRetryPolicy keeps retrying every request for up to 5 minutes, e.g. to report the result of a job
+	PersistentRetryPolicy = &RetryPolicy{MaxAttempts: 11, Budget: 5 * time.Minute, MinBackoff: 5 * time.Second, MaxBackoff: time.Minute, AllMethods: true}
+	// NoRetryPolicy sends every request only once
+	NoRetryPolicy = &RetryPolicy{MaxAttempts: 1}
+)
+
+type retryPolicyKey struct{}
+
+// WithRetryPolicy returns a context, which replaces the RetryPolicy of the connection for requests sent with it
+func WithRetryPolicy(ctx context.Context, policy *RetryPolicy) context.Context {
+	return context.WithValue(ctx, retryPolicyKey{}, policy)
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// shouldRetry reports whether a request with method, which failed with err, may be sent again
+func (p *RetryPolicy) shouldRetry(method string, err error) bool {
+	var serr *ServiceError
+	if errors.As(err, &serr) {
+		// The request has been rejected without processing it
+		if serr.StatusCode == http.StatusTooManyRequests || serr.StatusCode == http.StatusServiceUnavailable || serr.RateLimited {
+			return true
+		}
+		return serr.Temporary() && (p.AllMethods || isIdempotent(method))
+	}
+	// The connection failed, the request may have been processed anyway
+	var uerr *url.Error
+	return errors.As(err, &uerr) && (p.AllMethods || isIdempotent(method))
+}
+
+// delay returns the wait time before the next attempt or false if the request shouldn't be retried
+func (p *RetryPolicy) delay(attempt int, method string, err error, backoff time.Duration, deadline time.Time) (time.Duration, bool) {
+	if attempt >= p.MaxAttempts || !p.shouldRetry(method, err) {
+		return 0, false
+	}
+	if retryAfter := RetryAfter(err); retryAfter > backoff {
+		backoff = retryAfter
+	}
+	if !deadline.IsZero() && time.Now().Add(backoff).After(deadline) {
+		return 0, false
+	}
+	return backoff, true
+}
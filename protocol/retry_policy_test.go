@@ -0,0 +1,47 @@
+package protocol
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	policy := &RetryPolicy{MaxAttempts: 3}
+	unavailable := &ServiceError{StatusCode: http.StatusServiceUnavailable, RetryAfter: 10 * time.Second}
+	internal := &ServiceError{StatusCode: http.StatusInternalServerError}
+	connectionFailure := &url.Error{Op: "Post", URL: "https://example.com", Err: http.ErrHandlerTimeout}
+
+	delay, retry := policy.delay(1, "POST", unavailable, time.Second, time.Time{})
+	assert.True(t, retry, "503 has not been processed")
+	assert.Equal(t, 10*time.Second, delay, "Retry-After is longer than the backoff")
+	_, retry = policy.delay(3, "GET", unavailable, time.Second, time.Time{})
+	assert.False(t, retry, "no attempts left")
+	_, retry = policy.delay(1, "GET", unavailable, time.Second, time.Now().Add(5*time.Second))
+	assert.False(t, retry, "Retry-After exceeds the budget")
+
+	assert.True(t, policy.shouldRetry("GET", internal))
+	assert.False(t, policy.shouldRetry("POST", internal))
+	assert.True(t, policy.shouldRetry("DELETE", connectionFailure))
+	assert.False(t, policy.shouldRetry("POST", connectionFailure))
+	assert.False(t, policy.shouldRetry("GET", &ServiceError{StatusCode: http.StatusNotFound}))
+	assert.True(t, (&RetryPolicy{AllMethods: true}).shouldRetry("POST", internal))
+}
+
+func TestRateLimitedServiceError(t *testing.T) {
+	response := &http.Response{StatusCode: http.StatusForbidden, Header: http.Header{}}
+	response.Header.Set("X-RateLimit-Remaining", "0")
+	response.Header.Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10))
+	serr := newServiceError(response, nil, "http failure")
+	assert.True(t, serr.RateLimited)
+	assert.True(t, serr.Temporary())
+	assert.InDelta(t, time.Minute.Seconds(), serr.RetryAfter.Seconds(), 2)
+	assert.True(t, DefaultRetryPolicy.shouldRetry("POST", serr))
+
+	response.Header.Set("X-RateLimit-Remaining", "10")
+	assert.False(t, newServiceError(response, nil, "http failure").Temporary())
+}
@@ -12,6 +12,7 @@ const (
 type Annotation struct {
 	Level                 AnnotationLevel `json:"level"`
 	Message               string          `json:"message"`
+	Title                 string          `json:"title"`
 	RawDetails            string          `json:"rawDetails"`
 	Path                  string          `json:"path"`
 	IsInfrastructureIssue bool            `json:"isInfrastructureIssue"`
@@ -57,6 +57,7 @@ func TimeLineRecordToStepResult(rec protocol.TimelineRecord) StepResult {
 		Name:        rec.Name,
 		StartedAt:   rec.StartTime,
 		CompletedAt: rec.FinishTime,
+		Annotations: annotations,
 	}
 }
 
@@ -74,6 +75,7 @@ func IssueToAnnotation(issue protocol.Issue) Annotation {
 	return Annotation{
 		Level:       IssueGetAnnotationLevel(issue.Type),
 		Message:     issue.Message,
+		Title:       issue.Data["title"],
 		Path:        path,
 		StartLine:   lineNumber,
 		EndLine:     endLineNumber,
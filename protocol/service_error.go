@@ -0,0 +1,129 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Type keys of exceptions and OAuth error codes, which are handled by the runner
+const (
+	TaskAgentNotFoundException       = "TaskAgentNotFoundException"
+	TaskAgentSessionExpiredException = "TaskAgentSessionExpiredException"
+	AccessDeniedException            = "AccessDeniedException"
+	InvalidClient                    = "invalid_client"
+)
+
+// ServiceError is a failed response of the actions service
+type ServiceError struct {
+	StatusCode int
+	// TypeName is the full .NET type name of the exception, e.g. GitHub.DistributedTask.WebApi.TaskAgentSessionExpiredException, GitHub.DistributedTask.WebApi
+	TypeName string
+	// TypeKey is the short name of the exception, e.g. TaskAgentSessionExpiredException
+	TypeKey string
+	// OAuthError is the error code of a failed token request, e.g. invalid_client
+	OAuthError string
+	Message    string
+	// RetryAfter is the delay requested by the service via the Retry-After header or until X-RateLimit-Reset, zero if absent
+	RetryAfter time.Duration
+	// RateLimited is set if X-RateLimit-Remaining reports an exhausted rate limit
+	RateLimited bool
+	description string
+}
+
+type serviceErrorBody struct {
+	Message          string `json:"message"`
+	TypeName         string `json:"typeName"`
+	TypeKey          string `json:"typeKey"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+func newServiceError(response *http.Response, body []byte, description string) *ServiceError {
+	now := time.Now()
+	serr := &ServiceError{
+		StatusCode:  response.StatusCode,
+		RetryAfter:  parseRetryAfter(response.Header.Get("Retry-After"), now),
+		RateLimited: response.Header.Get("X-RateLimit-Remaining") == "0",
+		description: description,
+	}
+	if serr.RateLimited && serr.RetryAfter == 0 {
+		serr.RetryAfter = parseRateLimitReset(response.Header.Get("X-RateLimit-Reset"), now)
+	}
+	parsed := &serviceErrorBody{}
+	if json.Unmarshal(body, parsed) == nil {
+		serr.TypeName = parsed.TypeName
+		serr.TypeKey = parsed.TypeKey
+		serr.OAuthError = parsed.Error
+		serr.Message = parsed.Message
+		if serr.Message == "" {
+			serr.Message = parsed.ErrorDescription
+		}
+		if serr.TypeKey == "" && serr.TypeName != "" {
+			// Older servers only send the type name
+			name := strings.TrimSpace(strings.SplitN(serr.TypeName, ",", 2)[0])
+			serr.TypeKey = name[strings.LastIndex(name, ".")+1:]
+		}
+	}
+	return serr
+}
+
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+// parseRateLimitReset returns the time until the unix timestamp value
+func parseRateLimitReset(value string, now time.Time) time.Duration {
+	reset, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0
+	}
+	if d := time.Unix(reset, 0).Sub(now); d > 0 {
+		return d
+	}
+	return 0
+}
+
+func (e *ServiceError) Error() string {
+	return e.description
+}
+
+// Matches reports whether the exception or OAuth error of the response is kind, e.g. TaskAgentSessionExpiredException
+func (e *ServiceError) Matches(kind string) bool {
+	return strings.EqualFold(e.TypeKey, kind) || strings.EqualFold(e.OAuthError, kind)
+}
+
+// Temporary reports whether the request may succeed if it is retried later
+func (e *ServiceError) Temporary() bool {
+	return e.RateLimited || e.StatusCode == http.StatusTooManyRequests || e.StatusCode == http.StatusRequestTimeout || e.StatusCode >= 500 && e.StatusCode != http.StatusNotImplemented
+}
+
+// IsServiceError reports whether err is caused by a ServiceError of the given kind, see ServiceError.Matches
+func IsServiceError(err error, kind string) bool {
+	var serr *ServiceError
+	return errors.As(err, &serr) && serr.Matches(kind)
+}
+
+// RetryAfter returns the delay requested by the service for err, zero if there is none
+func RetryAfter(err error) time.Duration {
+	var serr *ServiceError
+	if errors.As(err, &serr) {
+		return serr.RetryAfter
+	}
+	return 0
+}
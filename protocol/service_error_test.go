@@ -0,0 +1,31 @@
+package protocol
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewServiceError(t *testing.T) {
+	response := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}
+	response.Header.Set("Retry-After", "120")
+	serr := newServiceError(response, []byte(`{"$id":"1","message":"The session has expired","typeName":"GitHub.DistributedTask.WebApi.TaskAgentSessionExpiredException, GitHub.DistributedTask.WebApi","errorCode":0}`), "http failure")
+	assert.Equal(t, "TaskAgentSessionExpiredException", serr.TypeKey, "the type key is derived from the type name")
+	assert.Equal(t, "The session has expired", serr.Message)
+	assert.Equal(t, 2*time.Minute, serr.RetryAfter)
+	assert.True(t, serr.Temporary())
+	assert.True(t, IsServiceError(serr, TaskAgentSessionExpiredException))
+	assert.False(t, IsServiceError(serr, AccessDeniedException))
+
+	serr = newServiceError(&http.Response{StatusCode: http.StatusUnauthorized}, []byte(`{"error":"invalid_client","error_description":"unknown client"}`), "Failed to Authorize")
+	assert.True(t, serr.Matches(InvalidClient))
+	assert.Equal(t, "unknown client", serr.Message)
+	assert.False(t, serr.Temporary())
+	assert.Equal(t, "Failed to Authorize", serr.Error())
+
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, 30*time.Second, parseRetryAfter(now.Add(30*time.Second).Format(http.TimeFormat), now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon", now))
+}
@@ -11,11 +11,8 @@ import (
 	"crypto/sha1"
 	"crypto/sha256"
 	"encoding/base64"
-	"errors"
 	"fmt"
 	"hash"
-	"io"
-	"time"
 )
 
 type TaskAgentMessage struct {
@@ -96,6 +93,7 @@ type AgentMessageConnection struct {
 	VssConnection    *VssConnection
 	TaskAgentSession *TaskAgentSession
 	Block            cipher.Block
+	listener         *MessageListener
 }
 
 func (session *AgentMessageConnection) Delete(ctx context.Context) error {
@@ -105,35 +103,16 @@ func (session *AgentMessageConnection) Delete(ctx context.Context) error {
 	}, map[string]string{}, session.TaskAgentSession, nil)
 }
 
-func (session *AgentMessageConnection) GetNextMessage(ctx context.Context) (*TaskAgentMessage, error) {
-	message := &TaskAgentMessage{}
-	for {
-		select {
-		case <-ctx.Done():
-			return nil, context.Canceled
-		default:
-		}
-		err := session.VssConnection.RequestWithContext(ctx, "c3a054f6-7a8a-49c0-944e-3a8e5d7adfd7", "5.1-preview", "GET", map[string]string{
-			"poolId": fmt.Sprint(session.VssConnection.PoolID),
-		}, map[string]string{
-			"sessionId": session.TaskAgentSession.SessionID,
-		}, nil, message)
-		// TODO lastMessageId=
-		if err != nil {
-			if errors.Is(err, context.Canceled) {
-				return nil, err
-			} else if !errors.Is(err, io.EOF) {
-				fmt.Printf("Failed to get message, waiting 10 sec before retry: %v\n", err.Error())
-				select {
-				case <-ctx.Done():
-					return nil, context.Canceled
-				case <-time.After(10 * time.Second):
-				}
-			}
-		} else {
-			return message, nil
-		}
+// Listener returns the MessageListener of the session, which keeps track of the received messages
+func (session *AgentMessageConnection) Listener() *MessageListener {
+	if session.listener == nil {
+		session.listener = NewMessageListener(session)
 	}
+	return session.listener
+}
+
+func (session *AgentMessageConnection) GetNextMessage(ctx context.Context) (*TaskAgentMessage, error) {
+	return session.Listener().GetNextMessage(ctx)
 }
 
 func (session *AgentMessageConnection) DeleteMessage(ctx context.Context, message *TaskAgentMessage) error {
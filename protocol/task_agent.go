@@ -2,6 +2,7 @@ package protocol
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -53,8 +54,14 @@ type TaskAgents struct {
 }
 
 func (taskAgent *TaskAgent) Authorize(c *http.Client, key interface{}) (*VssOAuthTokenResponse, error) {
+	tokenresp, _, err := taskAgent.authorize(context.Background(), c, key, time.Now())
+	return tokenresp, err
+}
+
+// authorize requests an access token with a client assertion issued at now, it also returns the Date header of the response
+func (taskAgent *TaskAgent) authorize(ctx context.Context, c *http.Client, key interface{}, now time.Time) (*VssOAuthTokenResponse, time.Time, error) {
 	tokenresp := &VssOAuthTokenResponse{}
-	now := time.Now().UTC().Add(-30 * time.Second)
+	now = now.UTC().Add(-jwtBackdate)
 	token2 := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.StandardClaims{
 		Subject:   taskAgent.Authorization.ClientID,
 		Issuer:    taskAgent.Authorization.ClientID,
@@ -66,7 +73,7 @@ func (taskAgent *TaskAgent) Authorize(c *http.Client, key interface{}) (*VssOAut
 	})
 	stkn, err := token2.SignedString(key)
 	if err != nil {
-		return nil, err
+		return nil, time.Time{}, err
 	}
 
 	data := url.Values{}
@@ -74,24 +81,25 @@ func (taskAgent *TaskAgent) Authorize(c *http.Client, key interface{}) (*VssOAut
 	data.Set("client_assertion", stkn)
 	data.Set("grant_type", "client_credentials")
 
-	poolsreq, err := http.NewRequest("POST", taskAgent.Authorization.AuthorizationURL, bytes.NewBufferString(data.Encode()))
+	poolsreq, err := http.NewRequestWithContext(ctx, "POST", taskAgent.Authorization.AuthorizationURL, bytes.NewBufferString(data.Encode()))
 	if err != nil {
-		return nil, errors.New("Failed to Authorize: " + err.Error())
+		return nil, time.Time{}, errors.New("Failed to Authorize: " + err.Error())
 	}
 	poolsreq.Header["Content-Type"] = []string{"application/x-www-form-urlencoded; charset=utf-8"}
 	poolsreq.Header["Accept"] = []string{"application/json"}
 	poolsresp, err := c.Do(poolsreq)
 	if err != nil {
-		return nil, errors.New("Failed to Authorize: " + err.Error())
+		return nil, time.Time{}, errors.New("Failed to Authorize: " + err.Error())
 	}
 	defer poolsresp.Body.Close()
+	date, _ := http.ParseTime(poolsresp.Header.Get("Date"))
 	if poolsresp.StatusCode != 200 {
 		bytes, _ := ioutil.ReadAll(poolsresp.Body)
-		return nil, errors.New("Failed to Authorize, service responded with code " + fmt.Sprint(poolsresp.StatusCode) + ": " + string(bytes))
+		return nil, date, newServiceError(poolsresp, bytes, "Failed to Authorize, service responded with code "+fmt.Sprint(poolsresp.StatusCode)+": "+string(bytes))
 	}
 	dec := json.NewDecoder(poolsresp.Body)
 	if err := dec.Decode(tokenresp); err != nil {
-		return nil, err
+		return nil, date, err
 	}
-	return tokenresp, nil
+	return tokenresp, date, nil
 }
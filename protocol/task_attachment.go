@@ -0,0 +1,13 @@
+package protocol
+
+// StepSummaryAttachmentType is the type of the timeline attachment with the markdown summary of a step
+const StepSummaryAttachmentType = "Checks.Step.Summary"
+
+type TaskAttachment struct {
+	Type          string
+	Name          string
+	TimelineID    string
+	RecordID      string
+	CreatedOn     string
+	LastChangedOn string
+}
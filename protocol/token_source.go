@@ -0,0 +1,104 @@
+package protocol
+
+import (
+	"context"
+	"crypto/rsa"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// jwtBackdate is subtracted from the issue time of the client assertion, it tolerates smaller clock differences to the actions service
+const jwtBackdate = 30 * time.Second
+
+// TokenSource provides the OAuth access token of a runner, it is safe for concurrent use.
+// The token is refreshed once 80% of its lifetime has passed, so requests rarely fail with an expired token.
+type TokenSource struct {
+	TaskAgent *TaskAgent
+	Key       *rsa.PrivateKey
+	Client    *http.Client
+
+	mu        sync.Mutex
+	token     string
+	refreshAt time.Time
+	expiresAt time.Time
+	// skew is the clock of the actions service minus the local clock, learned from the Date header of token responses
+	skew time.Duration
+	// hasSkew is set once a token response had a Date header, the first assertion is signed with the local clock
+	hasSkew bool
+}
+
+// NewTokenSource creates a token source for the runner, client sends the token requests
+func NewTokenSource(taskAgent *TaskAgent, key *rsa.PrivateKey, client *http.Client) *TokenSource {
+	return &TokenSource{TaskAgent: taskAgent, Key: key, Client: client}
+}
+
+// Token returns a valid access token, it requests a new one if the current token is about to expire
+func (ts *TokenSource) Token(ctx context.Context) (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	now := time.Now()
+	if ts.token != "" && now.Before(ts.refreshAt) {
+		return ts.token, nil
+	}
+	token, err := ts.refresh(ctx)
+	if err != nil {
+		if ts.token != "" && now.Before(ts.expiresAt) {
+			// The current token is still valid, the next call tries again
+			return ts.token, nil
+		}
+		return "", err
+	}
+	return token, nil
+}
+
+// Invalidate drops token after the actions service rejected it, unless it has already been replaced
+func (ts *TokenSource) Invalidate(token string) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.token == token {
+		ts.token = ""
+	}
+}
+
+// refresh requires the lock to be held
+func (ts *TokenSource) refresh(ctx context.Context) (string, error) {
+	skew, hasSkew := ts.skew, ts.hasSkew
+	resp, date, err := ts.TaskAgent.authorize(ctx, ts.Client, ts.Key, time.Now().Add(skew))
+	if !date.IsZero() {
+		// The Date header has a resolution of a second
+		ts.skew = date.Sub(time.Now()).Round(time.Second)
+		ts.hasSkew = true
+	}
+	if err != nil && !date.IsZero() && (!hasSkew || absDuration(ts.skew-skew) >= jwtBackdate/2) {
+		// The first assertion has been signed without knowing the clock of the service, or the clock difference has changed.
+		// Sign it again with the clock of the service
+		resp, _, err = ts.TaskAgent.authorize(ctx, ts.Client, ts.Key, time.Now().Add(ts.skew))
+		if err != nil && absDuration(ts.skew) >= jwtBackdate/2 {
+			return "", fmt.Errorf("%w (the local clock differs by %v from the clock of the actions service, please synchronize the clock of this machine)", err, -ts.skew)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	issued := time.Now()
+	ts.token = resp.AccessToken
+	if resp.ExpiresIn > 0 {
+		lifetime := time.Duration(resp.ExpiresIn) * time.Second
+		ts.expiresAt = issued.Add(lifetime)
+		ts.refreshAt = issued.Add(lifetime * 4 / 5)
+	} else {
+		// Unknown lifetime, keep it until it is rejected
+		ts.expiresAt = issued.Add(24 * time.Hour)
+		ts.refreshAt = ts.expiresAt
+	}
+	return ts.token, nil
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
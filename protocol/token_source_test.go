@@ -0,0 +1,107 @@
+package protocol
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenSource(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	// The local clock is two minutes ahead of the service
+	serverSkew := -2 * time.Minute
+	var mu sync.Mutex
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		now := time.Now().Add(serverSkew)
+		w.Header().Set("Date", now.UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Type", "application/json")
+		_ = r.ParseForm()
+		claims := jwt.StandardClaims{}
+		_, err := jwt.ParseWithClaims(r.PostForm.Get("client_assertion"), &claims, func(token *jwt.Token) (interface{}, error) {
+			return &key.PublicKey, nil
+		})
+		// jwt validates the claims with the local clock
+		if err != nil && err.(*jwt.ValidationError).Errors&jwt.ValidationErrorSignatureInvalid != 0 || claims.NotBefore > now.Unix() || claims.ExpiresAt < now.Unix() {
+			w.WriteHeader(http.StatusUnauthorized)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client", "error_description": "invalid client assertion"})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(&VssOAuthTokenResponse{AccessToken: "token", ExpiresIn: 3600, TokenType: "bearer"})
+	}))
+	defer server.Close()
+
+	ts := NewTokenSource(&TaskAgent{Authorization: TaskAgentAuthorization{ClientID: "client", AuthorizationURL: server.URL}}, key, server.Client())
+	wg := sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := ts.Token(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, "token", token)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 2, requests, "the first assertion is rejected due to the clock difference, all callers share the second token")
+	assert.Equal(t, serverSkew, ts.skew.Round(time.Minute))
+	assert.True(t, ts.refreshAt.Before(ts.expiresAt))
+
+	// The token is refreshed before it expires
+	ts.refreshAt = time.Now()
+	_, err = ts.Token(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 3, requests, "the learned clock difference is used for the next assertion")
+
+	ts.Invalidate("other")
+	_, err = ts.Token(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 3, requests)
+	ts.Invalidate("token")
+	_, err = ts.Token(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 4, requests)
+}
+
+func TestTokenSourceClockSkewError(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	var mu sync.Mutex
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		// The service is ten minutes ahead and rejects every assertion
+		w.Header().Set("Date", time.Now().Add(10*time.Minute).UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client", "error_description": "invalid client assertion"})
+	}))
+	defer server.Close()
+
+	ts := NewTokenSource(&TaskAgent{Authorization: TaskAgentAuthorization{ClientID: "client", AuthorizationURL: server.URL}}, key, server.Client())
+	_, err = ts.Token(context.Background())
+	assert.ErrorContains(t, err, "invalid client assertion")
+	assert.ErrorContains(t, err, "from the clock of the actions service, please synchronize the clock of this machine")
+	assert.Equal(t, 10*time.Minute, ts.skew.Round(time.Minute))
+	assert.Equal(t, 2, requests, "the assertion is signed again with the clock of the service")
+
+	// The clock difference is known, the next assertion is only signed once
+	_, err = ts.Token(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, 3, requests)
+}
@@ -3,7 +3,6 @@ package runnerconfiguration
 import (
 	"crypto/rand"
 	"crypto/rsa"
-	"crypto/x509"
 	"encoding/base64"
 	"encoding/binary"
 	"fmt"
@@ -99,7 +98,15 @@ func (config *ConfigureRunner) Configure(settings *RunnerSettings, survey Survey
 		}
 	}
 	key, _ := rsa.GenerateKey(rand.Reader, 2048)
-	instance.Key = base64.StdEncoding.EncodeToString(x509.MarshalPKCS1PrivateKey(key))
+	if err := instance.StoreKey(config.KeyProvider, key); err != nil {
+		return nil, err
+	}
+	registered := false
+	defer func() {
+		if !registered {
+			_ = instance.DeleteKey()
+		}
+	}()
 
 	taskAgent := &protocol.TaskAgent{}
 	bs := make([]byte, 4)
@@ -182,6 +189,7 @@ func (config *ConfigureRunner) Configure(settings *RunnerSettings, survey Survey
 	instance.Agent = taskAgent
 	instance.PoolID = vssConnection.PoolID
 	settings.Instances = append(settings.Instances, instance)
+	registered = true
 	return settings, nil
 }
 
@@ -212,4 +220,9 @@ func (config *ConfigureRunner) ReadFromEnvironment() {
 			config.Replace = v
 		}
 	}
+	if len(config.KeyProvider) == 0 {
+		if v, ok := os.LookupEnv("ACTIONS_RUNNER_INPUT_KEY_PROVIDER"); ok {
+			config.KeyProvider = v
+		}
+	}
 }
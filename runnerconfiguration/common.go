@@ -3,7 +3,6 @@ package runnerconfiguration
 import (
 	"bytes"
 	"crypto/rsa"
-	"crypto/tls"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
@@ -14,7 +13,6 @@ import (
 	"os"
 	"path"
 	"strings"
-	"time"
 
 	"github.com/ChristopherHX/github-act-runner/common"
 	"github.com/ChristopherHX/github-act-runner/protocol"
@@ -34,14 +32,8 @@ func (c *ConfigureRemoveRunner) GetHttpClient() *http.Client {
 	if c.Client != nil {
 		return c.Client
 	}
-	customTransport := http.DefaultTransport.(*http.Transport).Clone()
-	if v, ok := common.LookupEnvBool("SKIP_TLS_CERT_VALIDATION"); ok && v {
-		customTransport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
-	}
-	c.Client = &http.Client{
-		Timeout:   100 * time.Second,
-		Transport: customTransport,
-	}
+	// An invalid http configuration fails the first request with a descriptive error
+	c.Client, _ = common.NewHttpClient()
 	return c.Client
 }
 
@@ -56,6 +48,8 @@ type ConfigureRunner struct {
 	Replace         bool
 	DisableUpdate   bool
 	WorkFolder      string
+	// KeyProvider is the name of the key provider for the private key of the new runner, see KeyProviders
+	KeyProvider string
 }
 
 type RemoveRunner struct {
@@ -68,13 +62,88 @@ type RunnerInstance struct {
 	RegistrationURL string
 	Auth            *protocol.GitHubAuthResult
 	Agent           *protocol.TaskAgent
-	Key             string
+	Key             string          // base64 encoded private key or the reference of the KeyProvider
+	KeyProvider     string          `json:",omitempty"`
 	PKey            *rsa.PrivateKey `json:"-"`
 	RunnerGuard     string
-	WorkFolder      string // Currently unused for actions/runner compat
+	WorkFolder      string // Base directory of the job workspaces, _work/<runner name> if empty
+	// Profile configures how the jobs of this instance are executed, nil runs them like every other instance
+	Profile *ExecutionProfile `json:",omitempty"`
+}
+
+// Workers of an ExecutionProfile
+const (
+	// WorkerAct runs the jobs with act inside the runner
+	WorkerAct = "act"
+	// WorkerExternal passes the jobs to the program of WorkerArgs, e.g. compat/actions-runner-worker.py to use Runner.Worker of actions/runner
+	WorkerExternal = "external"
+)
+
+// ExecutionProfile allows instances sharing a host to execute their jobs differently
+type ExecutionProfile struct {
+	// Worker is WorkerAct or WorkerExternal, empty uses an external worker if WorkerArgs or the --worker-args of the run command are set
+	Worker string `json:",omitempty"`
+	// WorkerArgs is the command line of the external worker, it replaces --worker-args of the run command
+	WorkerArgs []string `json:",omitempty"`
+	// Env is added to the environment of the worker, the env of the workflow takes precedence
+	Env map[string]string `json:",omitempty"`
+	// WorkFolder is the base directory of the job workspaces, it replaces the WorkFolder of the instance
+	WorkFolder string `json:",omitempty"`
+	// ContainerRuntime is the address of the docker compatible daemon for job containers, e.g. unix:///run/user/1000/podman/podman.sock
+	ContainerRuntime string `json:",omitempty"`
+}
+
+// WorkerCommand returns the command line of the external worker or nil for the builtin act worker, defaultArgs are the --worker-args of the run command
+func (p *ExecutionProfile) WorkerCommand(defaultArgs []string) ([]string, error) {
+	if p == nil {
+		return defaultArgs, nil
+	}
+	args := defaultArgs
+	if len(p.WorkerArgs) > 0 {
+		args = p.WorkerArgs
+	}
+	switch p.Worker {
+	case "":
+		return args, nil
+	case WorkerAct:
+		return nil, nil
+	case WorkerExternal:
+		if len(args) == 0 {
+			return nil, fmt.Errorf("the external worker requires WorkerArgs")
+		}
+		return args, nil
+	default:
+		return nil, fmt.Errorf("unknown worker %v, expected %v or %v", p.Worker, WorkerAct, WorkerExternal)
+	}
+}
+
+// Environ returns the environment of an external worker
+func (p *ExecutionProfile) Environ() []string {
+	env := os.Environ()
+	if p == nil {
+		return env
+	}
+	for k, v := range p.Env {
+		env = append(env, k+"="+v)
+	}
+	if p.ContainerRuntime != "" {
+		env = append(env, "DOCKER_HOST="+p.ContainerRuntime)
+	}
+	return env
 }
 
 func (instance *RunnerInstance) EnshurePKey() error {
+	if instance.PKey == nil && instance.KeyProvider != "" {
+		provider, err := GetKeyProvider(instance.KeyProvider)
+		if err != nil {
+			return err
+		}
+		pkey, err := provider.Load(instance.Key)
+		if err != nil {
+			return fmt.Errorf("failed to load the private key with the %v key provider: %w", instance.KeyProvider, err)
+		}
+		instance.PKey = pkey
+	}
 	if instance.PKey == nil {
 		key, err := base64.StdEncoding.DecodeString(instance.Key)
 		if err != nil {
@@ -189,6 +258,7 @@ func (config *RemoveRunner) Authenticate(c *http.Client, survey Survey) (*protoc
 func (config *ConfigureRunner) Authenicate(c *http.Client, survey Survey) (*protocol.GitHubAuthResult, error) {
 	return config.Authenticate(c, survey)
 }
+
 // Deprecated: Use the Authenticate method.
 func (config *RemoveRunner) Authenicate(c *http.Client, survey Survey) (*protocol.GitHubAuthResult, error) {
 	return config.Authenticate(c, survey)
@@ -0,0 +1,23 @@
+package runnerconfiguration
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecutionProfile(t *testing.T) {
+	var profile *ExecutionProfile
+	args, err := profile.WorkerCommand([]string{"worker"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"worker"}, args)
+
+	_, err = (&ExecutionProfile{Worker: WorkerExternal}).WorkerCommand(nil)
+	assert.Error(t, err)
+	_, err = (&ExecutionProfile{Worker: "podman"}).WorkerCommand(nil)
+	assert.Error(t, err)
+
+	env := (&ExecutionProfile{Env: map[string]string{"RUNNER_TOOL_CACHE": "/opt/hostedtoolcache"}, ContainerRuntime: "unix:///run/podman/podman.sock"}).Environ()
+	assert.Contains(t, env, "RUNNER_TOOL_CACHE=/opt/hostedtoolcache")
+	assert.Equal(t, "DOCKER_HOST=unix:///run/podman/podman.sock", env[len(env)-1], "the container runtime overrides DOCKER_HOST of the runner")
+}
@@ -0,0 +1,258 @@
+package runnerconfiguration
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/scrypt"
+)
+
+// KeyProvider stores the private keys of runner instances outside of settings.json
+type KeyProvider interface {
+	// Store saves the key and returns the reference to load it again, name is unique for every runner instance
+	Store(name string, key *rsa.PrivateKey) (string, error)
+	Load(ref string) (*rsa.PrivateKey, error)
+	Delete(ref string) error
+}
+
+// KeyProviders creates the key providers by name, without a key provider the private key is saved base64 encoded in settings.json
+var KeyProviders = map[string]func() (KeyProvider, error){
+	"file":    NewFileKeyProvider,
+	"keyring": NewKeyringKeyProvider,
+	"command": NewCommandKeyProvider,
+}
+
+func GetKeyProvider(name string) (KeyProvider, error) {
+	newProvider, ok := KeyProviders[name]
+	if !ok {
+		names := make([]string, 0, len(KeyProviders))
+		for name := range KeyProviders {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown key provider %v, available are %v", name, strings.Join(names, ", "))
+	}
+	return newProvider()
+}
+
+// StoreKey saves the private key of the instance with the named key provider, an empty name saves it in settings.json
+func (instance *RunnerInstance) StoreKey(keyProvider string, key *rsa.PrivateKey) error {
+	if keyProvider == "" {
+		instance.Key = base64.StdEncoding.EncodeToString(x509.MarshalPKCS1PrivateKey(key))
+	} else {
+		provider, err := GetKeyProvider(keyProvider)
+		if err != nil {
+			return err
+		}
+		ref, err := provider.Store("github-act-runner-"+uuid.NewString(), key)
+		if err != nil {
+			return fmt.Errorf("failed to store the private key with the %v key provider: %w", keyProvider, err)
+		}
+		instance.Key = ref
+	}
+	instance.KeyProvider = keyProvider
+	instance.PKey = key
+	return nil
+}
+
+// DeleteKey removes the private key of the instance from its key provider
+func (instance *RunnerInstance) DeleteKey() error {
+	if instance.KeyProvider == "" {
+		return nil
+	}
+	provider, err := GetKeyProvider(instance.KeyProvider)
+	if err != nil {
+		return err
+	}
+	return provider.Delete(instance.Key)
+}
+
+// FileKeyProvider saves every key AES-GCM encrypted into its own file, the encryption key is derived from Passphrase via scrypt
+type FileKeyProvider struct {
+	Passphrase []byte
+}
+
+type encryptedKeyFile struct {
+	Version int
+	Salt    string
+	Nonce   string
+	Data    string
+}
+
+// NewFileKeyProvider reads the passphrase from ACTIONS_RUNNER_KEY_PASSPHRASE or from the file ACTIONS_RUNNER_KEY_PASSPHRASE_FILE
+func NewFileKeyProvider() (KeyProvider, error) {
+	if passphrase, ok := os.LookupEnv("ACTIONS_RUNNER_KEY_PASSPHRASE"); ok && passphrase != "" {
+		return &FileKeyProvider{Passphrase: []byte(passphrase)}, nil
+	}
+	if keyFile, ok := os.LookupEnv("ACTIONS_RUNNER_KEY_PASSPHRASE_FILE"); ok && keyFile != "" {
+		passphrase, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		passphrase = bytes.TrimRight(passphrase, "\r\n")
+		if len(passphrase) == 0 {
+			return nil, fmt.Errorf("%v is empty", keyFile)
+		}
+		return &FileKeyProvider{Passphrase: passphrase}, nil
+	}
+	return nil, fmt.Errorf("the file key provider requires ACTIONS_RUNNER_KEY_PASSPHRASE or ACTIONS_RUNNER_KEY_PASSPHRASE_FILE")
+}
+
+func (p *FileKeyProvider) aead(salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(p.Passphrase, salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (p *FileKeyProvider) Store(name string, key *rsa.PrivateKey) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	aead, err := p.aead(salt)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	ref := name + ".key"
+	content, err := json.Marshal(&encryptedKeyFile{
+		Version: 1,
+		Salt:    base64.StdEncoding.EncodeToString(salt),
+		Nonce:   base64.StdEncoding.EncodeToString(nonce),
+		// The file name is authenticated, swapping the files of two runners fails to decrypt
+		Data: base64.StdEncoding.EncodeToString(aead.Seal(nil, nonce, x509.MarshalPKCS1PrivateKey(key), []byte(ref))),
+	})
+	if err != nil {
+		return "", err
+	}
+	f, err := os.OpenFile(ref, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return "", err
+	}
+	return ref, f.Close()
+}
+
+func (p *FileKeyProvider) Load(ref string) (*rsa.PrivateKey, error) {
+	content, err := ioutil.ReadFile(ref)
+	if err != nil {
+		return nil, err
+	}
+	keyFile := &encryptedKeyFile{}
+	if err := json.Unmarshal(content, keyFile); err != nil {
+		return nil, err
+	}
+	if keyFile.Version != 1 {
+		return nil, fmt.Errorf("unsupported version %v of %v", keyFile.Version, ref)
+	}
+	salt, err := base64.StdEncoding.DecodeString(keyFile.Salt)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := base64.StdEncoding.DecodeString(keyFile.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	data, err := base64.StdEncoding.DecodeString(keyFile.Data)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := p.aead(salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce in %v", ref)
+	}
+	der, err := aead.Open(nil, nonce, data, []byte(ref))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %v, wrong passphrase?", ref)
+	}
+	return x509.ParsePKCS1PrivateKey(der)
+}
+
+func (p *FileKeyProvider) Delete(ref string) error {
+	return os.Remove(ref)
+}
+
+// CommandKeyProvider delegates to an external program, e.g. a wrapper of a secret manager.
+// The program is called with the arguments `store <name>`, `load <ref>` or `delete <ref>`.
+// Keys are exchanged as base64 encoded PKCS1 via stdin of store and stdout of load,
+// store may print a reference to use instead of name.
+type CommandKeyProvider struct {
+	Command []string
+}
+
+// NewCommandKeyProvider reads the command line from ACTIONS_RUNNER_KEY_COMMAND
+func NewCommandKeyProvider() (KeyProvider, error) {
+	command := strings.Fields(os.Getenv("ACTIONS_RUNNER_KEY_COMMAND"))
+	if len(command) == 0 {
+		return nil, fmt.Errorf("the command key provider requires ACTIONS_RUNNER_KEY_COMMAND")
+	}
+	return &CommandKeyProvider{Command: command}, nil
+}
+
+func (p *CommandKeyProvider) run(stdin []byte, args ...string) ([]byte, error) {
+	args = append(append([]string{}, p.Command[1:]...), args...)
+	cmd := exec.Command(p.Command[0], args...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%v %v failed: %w", p.Command[0], args[len(args)-2], err)
+	}
+	return bytes.TrimSpace(out), nil
+}
+
+func (p *CommandKeyProvider) Store(name string, key *rsa.PrivateKey) (string, error) {
+	out, err := p.run([]byte(base64.StdEncoding.EncodeToString(x509.MarshalPKCS1PrivateKey(key))), "store", name)
+	if err != nil {
+		return "", err
+	}
+	if len(out) > 0 {
+		return string(out), nil
+	}
+	return name, nil
+}
+
+func (p *CommandKeyProvider) Load(ref string) (*rsa.PrivateKey, error) {
+	out, err := p.run(nil, "load", ref)
+	if err != nil {
+		return nil, err
+	}
+	der, err := base64.StdEncoding.DecodeString(string(out))
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParsePKCS1PrivateKey(der)
+}
+
+func (p *CommandKeyProvider) Delete(ref string) error {
+	_, err := p.run(nil, "delete", ref)
+	return err
+}
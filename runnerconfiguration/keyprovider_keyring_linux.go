@@ -0,0 +1,53 @@
+package runnerconfiguration
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+
+	"golang.org/x/sys/unix"
+)
+
+// KeyringKeyProvider saves keys in the user keyring of the linux kernel, keys don't survive a reboot
+type KeyringKeyProvider struct {
+}
+
+func NewKeyringKeyProvider() (KeyProvider, error) {
+	return &KeyringKeyProvider{}, nil
+}
+
+func (p *KeyringKeyProvider) Store(name string, key *rsa.PrivateKey) (string, error) {
+	id, err := unix.AddKey("user", name, x509.MarshalPKCS1PrivateKey(key), unix.KEY_SPEC_USER_KEYRING)
+	if err != nil {
+		return "", err
+	}
+	// Only processes of the same user are allowed to read the key, even without possessing the keyring
+	if err := unix.KeyctlSetperm(id, 0x3f3f0000); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+func (p *KeyringKeyProvider) Load(ref string) (*rsa.PrivateKey, error) {
+	id, err := unix.KeyctlSearch(unix.KEY_SPEC_USER_KEYRING, "user", ref, 0)
+	if err != nil {
+		return nil, err
+	}
+	size, err := unix.KeyctlBuffer(unix.KEYCTL_READ, id, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	der := make([]byte, size)
+	if _, err := unix.KeyctlBuffer(unix.KEYCTL_READ, id, der, 0); err != nil {
+		return nil, err
+	}
+	return x509.ParsePKCS1PrivateKey(der)
+}
+
+func (p *KeyringKeyProvider) Delete(ref string) error {
+	id, err := unix.KeyctlSearch(unix.KEY_SPEC_USER_KEYRING, "user", ref, 0)
+	if err != nil {
+		return err
+	}
+	_, err = unix.KeyctlInt(unix.KEYCTL_UNLINK, id, unix.KEY_SPEC_USER_KEYRING, 0, 0)
+	return err
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package runnerconfiguration
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func NewKeyringKeyProvider() (KeyProvider, error) {
+	return nil, fmt.Errorf("the keyring key provider is not supported on %v", runtime.GOOS)
+}
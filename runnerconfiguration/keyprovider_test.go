@@ -0,0 +1,67 @@
+package runnerconfiguration
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileKeyProvider(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.NoError(t, err)
+	provider := &FileKeyProvider{Passphrase: []byte("passphrase")}
+	ref, err := provider.Store(filepath.Join(t.TempDir(), "runner"), key)
+	assert.NoError(t, err)
+
+	info, err := os.Stat(ref)
+	if assert.NoError(t, err) && filepath.Separator == '/' {
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+	loaded, err := provider.Load(ref)
+	assert.NoError(t, err)
+	assert.True(t, key.Equal(loaded))
+
+	_, err = (&FileKeyProvider{Passphrase: []byte("wrong")}).Load(ref)
+	assert.Error(t, err)
+
+	assert.NoError(t, provider.Delete(ref))
+	_, err = provider.Load(ref)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestEnshurePKeyWithKeyProvider(t *testing.T) {
+	dir := t.TempDir()
+	KeyProviders["test"] = func() (KeyProvider, error) {
+		return &testKeyProvider{dir: dir, FileKeyProvider: FileKeyProvider{Passphrase: []byte("passphrase")}}, nil
+	}
+	defer delete(KeyProviders, "test")
+
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.NoError(t, err)
+	instance := &RunnerInstance{}
+	assert.NoError(t, instance.StoreKey("test", key))
+	assert.NotContains(t, instance.Key, "MII", "the key must not end up in settings.json")
+
+	reloaded := &RunnerInstance{Key: instance.Key, KeyProvider: instance.KeyProvider}
+	assert.NoError(t, reloaded.EnshurePKey())
+	assert.True(t, key.Equal(reloaded.PKey))
+
+	assert.NoError(t, instance.DeleteKey())
+	assert.Error(t, (&RunnerInstance{Key: instance.Key, KeyProvider: instance.KeyProvider}).EnshurePKey())
+
+	_, err = GetKeyProvider("unknown")
+	assert.Error(t, err)
+}
+
+type testKeyProvider struct {
+	FileKeyProvider
+	dir string
+}
+
+func (p *testKeyProvider) Store(name string, key *rsa.PrivateKey) (string, error) {
+	return p.FileKeyProvider.Store(filepath.Join(p.dir, name), key)
+}
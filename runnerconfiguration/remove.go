@@ -96,6 +96,8 @@ func (config *RemoveRunner) Remove(settings *RunnerSettings, survey Survey, auth
 				break
 			}
 		}
+		// The key is useless after removing the runner, a failure only leaves garbage behind
+		_ = instance.DeleteKey()
 	}
 	return settings, nil
 }